@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	ErrorNoToken      = "access token required"
+	ErrorBadToken     = "access token invalid"
+	ErrorExpiredToken = "access token expired"
+
+	// authRealm - realm в WWW-Authenticate ответов 401
+	authRealm = "SearchServer"
+)
+
+// Authenticator проверяет, можно ли выполнить запрос к Server.
+// Ошибка с одним из кодов ErrorNoToken, ErrorBadToken, ErrorExpiredToken уходит клиенту в SearchErrorResponse.
+type Authenticator interface {
+	Authenticate(r *http.Request) error
+}
+
+// requestToken достает токен из Authorization: Bearer, а если его нет - из старого заголовка AccessToken
+func requestToken(r *http.Request) string {
+	if scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " "); found && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+
+	return r.Header.Get("AccessToken")
+}
+
+// StaticTokens - заранее известный список токенов
+type StaticTokens []string
+
+// LoadTokenFile читает токены из файла: по одному на строку, пустые строки и строки с # пропускаются
+func LoadTokenFile(path string) (StaticTokens, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tokens := StaticTokens{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, line)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (t StaticTokens) Authenticate(r *http.Request) error {
+	token := requestToken(r)
+	if len(token) == 0 {
+		return fmt.Errorf(ErrorNoToken)
+	}
+	// сравниваем со всеми токенами за постоянное время, чтобы не подсказывать совпавший префикс
+	found := 0
+	for _, known := range t {
+		found |= subtle.ConstantTimeCompare([]byte(token), []byte(known))
+	}
+	if found == 0 {
+		return fmt.Errorf(ErrorBadToken)
+	}
+
+	return nil
+}
+
+// HMACTokens - токены, подписанные Secret, со сроком действия. Выдаются через Issue, хранить их на сервере не нужно.
+// С пустым Secret токены не выдаются и не принимаются.
+type HMACTokens struct {
+	Secret []byte
+
+	// часы для тестов, nil - time.Now
+	now func() time.Time
+}
+
+// hmacClaims - содержимое токена HMACTokens
+type hmacClaims struct {
+	Subject string `json:"sub"`
+	Expires int64  `json:"exp"`
+}
+
+// Issue выдает токен для subject, действующий ttl
+func (a *HMACTokens) Issue(subject string, ttl time.Duration) (string, error) {
+	if len(a.Secret) == 0 {
+		return "", fmt.Errorf("empty HMAC secret")
+	}
+	payload, err := json.Marshal(hmacClaims{Subject: subject, Expires: a.clock().Add(ttl).Unix()})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(a.sign(payload)), nil
+}
+
+func (a *HMACTokens) Authenticate(r *http.Request) error {
+	token := requestToken(r)
+	if len(token) == 0 {
+		return fmt.Errorf(ErrorNoToken)
+	}
+	if len(a.Secret) == 0 {
+		return fmt.Errorf(ErrorBadToken)
+	}
+
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found {
+		return fmt.Errorf(ErrorBadToken)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf(ErrorBadToken)
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, a.sign(payload)) {
+		return fmt.Errorf(ErrorBadToken)
+	}
+
+	claims := hmacClaims{}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return fmt.Errorf(ErrorBadToken)
+	}
+	if !a.clock().Before(time.Unix(claims.Expires, 0)) {
+		return fmt.Errorf(ErrorExpiredToken)
+	}
+
+	return nil
+}
+
+func (a *HMACTokens) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, a.Secret)
+	mac.Write(payload) //nolint:errcheck
+
+	return mac.Sum(nil)
+}
+
+func (a *HMACTokens) clock() time.Time {
+	if a.now != nil {
+		return a.now()
+	}
+
+	return time.Now()
+}
+
+// unauthorized отвечает 401 с кодом ошибки в теле и схемой аутентификации в WWW-Authenticate
+func unauthorized(w http.ResponseWriter, desc string) {
+	resp, err := json.Marshal(SearchErrorResponse{Error: desc})
+	if err != nil {
+		internalServerError(w, err.Error())
+		return
+	}
+
+	// RFC 6750: без токена - только схема, с неподходящим токеном - еще и invalid_token
+	challenge := fmt.Sprintf("Bearer realm=%q", authRealm)
+	if desc != ErrorNoToken {
+		challenge += fmt.Sprintf(", error=\"invalid_token\", error_description=%q", desc)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	if _, err = w.Write(resp); err != nil {
+		internalServerError(w, err.Error())
+		return
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthenticators(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	hmacAuth := &HMACTokens{Secret: []byte("secret"), now: func() time.Time { return now }}
+	valid, err := hmacAuth.Issue("alice", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := hmacAuth.Issue("alice", -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, err := (&HMACTokens{Secret: []byte("other"), now: hmacAuth.now}).Issue("alice", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	static := StaticTokens{"first", "second"}
+
+	// токен, подписанный пустым ключом, Issue такой не выдает
+	emptySecret := &HMACTokens{now: hmacAuth.now}
+	if _, err = emptySecret.Issue("alice", time.Hour); err == nil {
+		t.Error("expected error issuing token with empty secret")
+	}
+	payload := []byte(`{"sub":"alice","exp":` + strconv.FormatInt(now.Add(time.Hour).Unix(), 10) + `}`)
+	unsigned := base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(emptySecret.sign(payload))
+
+	cases := map[string]struct {
+		Auth          Authenticator
+		AccessToken   string
+		Authorization string
+		Error         string
+	}{
+		"static legacy header": {Auth: static, AccessToken: "second"},
+		"static bearer":        {Auth: static, Authorization: "Bearer first"},
+		"bearer case":          {Auth: static, Authorization: "bearer first"},
+		"bearer wins":          {Auth: static, AccessToken: "first", Authorization: "Bearer wrong", Error: ErrorBadToken},
+		"other scheme":         {Auth: static, AccessToken: "first", Authorization: "Basic Zmlyc3Q="},
+		"static unknown":       {Auth: static, AccessToken: "firs", Error: ErrorBadToken},
+		"static empty":         {Auth: static, Error: ErrorNoToken},
+		"hmac valid":           {Auth: hmacAuth, Authorization: "Bearer " + valid},
+		"hmac legacy header":   {Auth: hmacAuth, AccessToken: valid},
+		"hmac expired":         {Auth: hmacAuth, AccessToken: expired, Error: ErrorExpiredToken},
+		"hmac other secret":    {Auth: hmacAuth, AccessToken: foreign, Error: ErrorBadToken},
+		"hmac garbage":         {Auth: hmacAuth, AccessToken: "token", Error: ErrorBadToken},
+		"hmac tampered":        {Auth: hmacAuth, AccessToken: "x" + valid, Error: ErrorBadToken},
+		"hmac empty":           {Auth: hmacAuth, Error: ErrorNoToken},
+		"hmac empty secret":    {Auth: emptySecret, AccessToken: unsigned, Error: ErrorBadToken},
+	}
+
+	for name, item := range cases {
+		req := httptest.NewRequest("GET", "/", nil)
+		if len(item.AccessToken) > 0 {
+			req.Header.Set("AccessToken", item.AccessToken)
+		}
+		if len(item.Authorization) > 0 {
+			req.Header.Set("Authorization", item.Authorization)
+		}
+
+		err := item.Auth.Authenticate(req)
+		if len(item.Error) == 0 && err != nil {
+			t.Errorf("[%s] unexpected error: %v", name, err)
+		}
+		if len(item.Error) > 0 && (err == nil || err.Error() != item.Error) {
+			t.Errorf("[%s] wrong error: got %v want %s", name, err, item.Error)
+		}
+	}
+}
+
+func TestLoadTokenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(path, []byte("# tokens\nfirst\n\n  second  \n#third\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := LoadTokenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(StaticTokens{"first", "second"}, tokens) {
+		t.Errorf("wrong tokens: %v", tokens)
+	}
+
+	if _, err = LoadTokenFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+}
+
+func TestServerUnauthorized(t *testing.T) {
+	FileDataset = "dataset.xml"
+
+	server := httptest.NewServer(&Server{Auth: StaticTokens{"secret"}})
+	defer server.Close()
+
+	cases := map[string]struct {
+		Token     string
+		Code      string
+		Challenge string
+	}{
+		"no token":    {Code: ErrorNoToken, Challenge: `Bearer realm="SearchServer"`},
+		"wrong token": {Token: "token", Code: ErrorBadToken, Challenge: `Bearer realm="SearchServer", error="invalid_token"`},
+	}
+
+	for name, item := range cases {
+		req, err := http.NewRequest("GET", server.URL+"?limit=1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("AccessToken", item.Token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		errResp := SearchErrorResponse{}
+		err = json.NewDecoder(resp.Body).Decode(&errResp)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusUnauthorized || err != nil || errResp.Error != item.Code {
+			t.Errorf("[%s] wrong response: %d %q %v", name, resp.StatusCode, errResp.Error, err)
+		}
+		if challenge := resp.Header.Get("WWW-Authenticate"); !strings.HasPrefix(challenge, item.Challenge) {
+			t.Errorf("[%s] wrong WWW-Authenticate: %q", name, challenge)
+		}
+
+		client := &SearchClient{AccessToken: item.Token, URL: server.URL}
+		_, err = client.FindUsers(SearchRequest{Limit: 1})
+		var apiErr *APIError
+		if !errors.Is(err, ErrBadAccessToken) || !errors.As(err, &apiErr) || apiErr.Code != item.Code {
+			t.Errorf("[%s] wrong client error: %v", name, err)
+		}
+	}
+
+	client := &SearchClient{AccessToken: "secret", URL: server.URL}
+	if _, err := client.FindUsers(SearchRequest{Limit: 1}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestServerWithoutAuth(t *testing.T) {
+	FileDataset = "dataset.xml"
+
+	req := httptest.NewRequest("GET", "/?limit=1", nil)
+	req.Header.Set("AccessToken", "token")
+	rr := httptest.NewRecorder()
+	(&Server{}).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("server without Auth must reject requests, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen - CircuitBreaker не пропустил запрос: сервер недавно отказывал и еще не остыл
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitState - состояние CircuitBreaker
+type CircuitState int
+
+const (
+	CircuitClosed   CircuitState = iota // запросы идут как обычно
+	CircuitOpen                         // запросы сразу получают ErrCircuitOpen
+	CircuitHalfOpen                     // по одному пробному запросу, чтобы понять, ожил ли сервер
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+
+	return "unknown"
+}
+
+// Значения CircuitBreaker по умолчанию
+const (
+	defaultFailureThreshold = 5
+	defaultCoolDown         = 5 * time.Second
+)
+
+// CircuitBreaker перестает ходить в SearchServer после FailureThreshold неудач подряд.
+// Неудача - сетевая ошибка, таймаут или ответ 500, 502, 503, 429 после всех повторов.
+// Через CoolDown пропускается пробный запрос: SuccessThreshold успешных проб закрывают цепь, неудачная снова открывает.
+// Один CircuitBreaker можно разделять между несколькими SearchClient.
+type CircuitBreaker struct {
+	// неудач подряд до открытия, 0 - defaultFailureThreshold
+	FailureThreshold int
+	// сколько цепь остается открытой, 0 - defaultCoolDown
+	CoolDown time.Duration
+	// успешных проб для закрытия, 0 - одна
+	SuccessThreshold int
+	// вызывается при каждой смене состояния, не под блокировкой
+	OnStateChange func(from, to CircuitState)
+
+	mu        sync.Mutex
+	state     CircuitState
+	failures  int
+	successes int
+	openedAt  time.Time
+	// пробный запрос в полуоткрытом состоянии уже идет
+	probing bool
+	// часы для тестов, nil - time.Now
+	now func() time.Time
+}
+
+// callOutcome - итог вызова FindUsers с точки зрения CircuitBreaker
+type callOutcome int
+
+const (
+	callSucceeded callOutcome = iota
+	callFailed
+	// вызов отменил сам клиент, о сервере он ничего не говорит
+	callIgnored
+)
+
+// State возвращает текущее состояние, например для метрик
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// allow решает, можно ли сейчас идти в сервер
+func (b *CircuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	from := b.state
+	err := b.allowLocked()
+	to := b.state
+	b.mu.Unlock()
+
+	b.notify(from, to)
+	return err
+}
+
+func (b *CircuitBreaker) allowLocked() error {
+	switch b.state {
+	case CircuitOpen:
+		coolDown := b.CoolDown
+		if coolDown <= 0 {
+			coolDown = defaultCoolDown
+		}
+		if b.clock().Sub(b.openedAt) < coolDown {
+			return ErrCircuitOpen
+		}
+		b.state = CircuitHalfOpen
+	case CircuitHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+	}
+	if b.state == CircuitHalfOpen {
+		b.probing = true
+	}
+
+	return nil
+}
+
+// record учитывает итог вызова, пропущенного allow
+func (b *CircuitBreaker) record(outcome callOutcome) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	from := b.state
+	b.recordLocked(outcome)
+	to := b.state
+	b.mu.Unlock()
+
+	b.notify(from, to)
+}
+
+func (b *CircuitBreaker) recordLocked(outcome callOutcome) {
+	switch b.state {
+	case CircuitClosed:
+		switch outcome {
+		case callSucceeded:
+			b.failures = 0
+		case callFailed:
+			b.failures++
+			threshold := b.FailureThreshold
+			if threshold <= 0 {
+				threshold = defaultFailureThreshold
+			}
+			if b.failures >= threshold {
+				b.open()
+			}
+		}
+	case CircuitHalfOpen:
+		b.probing = false
+		switch outcome {
+		case callSucceeded:
+			b.successes++
+			if b.successes >= max(b.SuccessThreshold, 1) {
+				b.state = CircuitClosed
+				b.failures, b.successes = 0, 0
+			}
+		case callFailed:
+			b.open()
+		}
+	}
+	// в открытом состоянии приходят только ответы на запросы, начатые до открытия, - их не учитываем
+}
+
+func (b *CircuitBreaker) open() {
+	b.state = CircuitOpen
+	b.openedAt = b.clock()
+	b.failures, b.successes = 0, 0
+}
+
+func (b *CircuitBreaker) notify(from, to CircuitState) {
+	if from != to && b.OnStateChange != nil {
+		b.OnStateChange(from, to)
+	}
+}
+
+func (b *CircuitBreaker) clock() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+
+	return time.Now()
+}
+
+// outcomeOf оценивает итог попыток запроса для CircuitBreaker
+func outcomeOf(resp *http.Response, err error) callOutcome {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return callIgnored
+	case err != nil:
+		return callFailed
+	case isRetryableStatus(resp.StatusCode):
+		return callFailed
+	}
+
+	return callSucceeded
+}
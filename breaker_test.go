@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	changes := []string{}
+	breaker := &CircuitBreaker{
+		FailureThreshold: 2,
+		CoolDown:         time.Minute,
+		SuccessThreshold: 2,
+		OnStateChange: func(from, to CircuitState) {
+			changes = append(changes, from.String()+">"+to.String())
+		},
+		now: func() time.Time { return now },
+	}
+
+	steps := []struct {
+		Name    string
+		Advance time.Duration
+		Allowed bool
+		Outcome callOutcome
+		State   CircuitState
+	}{
+		{Name: "first failure", Allowed: true, Outcome: callFailed, State: CircuitClosed},
+		{Name: "success resets", Allowed: true, Outcome: callSucceeded, State: CircuitClosed},
+		{Name: "failure", Allowed: true, Outcome: callFailed, State: CircuitClosed},
+		{Name: "threshold opens", Allowed: true, Outcome: callFailed, State: CircuitOpen},
+		{Name: "open fails fast", Advance: 30 * time.Second, Allowed: false, State: CircuitOpen},
+		{Name: "probe fails", Advance: 30 * time.Second, Allowed: true, Outcome: callFailed, State: CircuitOpen},
+		{Name: "cooling again", Allowed: false, State: CircuitOpen},
+		{Name: "canceled probe", Advance: time.Minute, Allowed: true, Outcome: callIgnored, State: CircuitHalfOpen},
+		{Name: "first probe", Allowed: true, Outcome: callSucceeded, State: CircuitHalfOpen},
+		{Name: "second probe closes", Allowed: true, Outcome: callSucceeded, State: CircuitClosed},
+	}
+
+	for _, step := range steps {
+		now = now.Add(step.Advance)
+		err := breaker.allow()
+		if step.Allowed != (err == nil) {
+			t.Fatalf("[%s] wrong allow result: %v", step.Name, err)
+		}
+		if err != nil && !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("[%s] expected ErrCircuitOpen, got %v", step.Name, err)
+		}
+		if err == nil {
+			breaker.record(step.Outcome)
+		}
+		if state := breaker.State(); state != step.State {
+			t.Fatalf("[%s] wrong state: got %v want %v", step.Name, state, step.State)
+		}
+	}
+
+	expected := []string{"closed>open", "open>half-open", "half-open>open", "open>half-open", "half-open>closed"}
+	if !reflect.DeepEqual(expected, changes) {
+		t.Errorf("wrong state changes: got %v want %v", changes, expected)
+	}
+}
+
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	breaker := &CircuitBreaker{FailureThreshold: 1, CoolDown: time.Second, now: func() time.Time { return now }}
+
+	if err := breaker.allow(); err != nil {
+		t.Fatal(err)
+	}
+	breaker.record(callFailed)
+	now = now.Add(time.Second)
+
+	if err := breaker.allow(); err != nil {
+		t.Fatalf("probe must be allowed: %v", err)
+	}
+	if err := breaker.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("second concurrent probe must fail fast, got %v", err)
+	}
+}
+
+func TestFindUsersCircuitBreaker(t *testing.T) {
+	FileDataset = "dataset.xml"
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	server, calls := flakyServer([]int{500, 503, 401}, nil)
+	defer server.Close()
+
+	breaker := &CircuitBreaker{FailureThreshold: 2, CoolDown: time.Second, now: func() time.Time { return now }}
+	client := &SearchClient{AccessToken: "token", URL: server.URL, Breaker: breaker}
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.FindUsers(SearchRequest{Limit: 1}); errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("circuit opened too early on call %d", i+1)
+		}
+	}
+	if _, err := client.FindUsers(SearchRequest{Limit: 1}); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("open circuit must not reach the server: %d calls", calls.Load())
+	}
+
+	// 401 - ошибка клиента, а не сервера: проба считается успешной
+	now = now.Add(time.Second)
+	if _, err := client.FindUsers(SearchRequest{Limit: 1}); !errors.Is(err, ErrBadAccessToken) {
+		t.Fatalf("expected bad token from probe, got %v", err)
+	}
+	if state := breaker.State(); state != CircuitClosed {
+		t.Errorf("wrong state after probe: %v", state)
+	}
+	if _, err := client.FindUsers(SearchRequest{Limit: 1}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
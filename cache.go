@@ -0,0 +1,158 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCacheSize - сколько ответов хранит ResponseCache без заданного Size
+const defaultCacheSize = 128
+
+// ResponseCache - LRU-кэш ответов FindUsers. Ключ - параметры запроса и токен.
+// Свежий ответ отдается без похода в сервер; устаревший с ETag перепроверяется через If-None-Match,
+// и на 304 снова отдается из кэша. Cache-Control: no-store, no-cache, max-age сервера важнее TTL.
+// Один ResponseCache можно разделять между несколькими SearchClient.
+type ResponseCache struct {
+	// сколько ответов хранить, 0 - defaultCacheSize
+	Size int
+	// сколько ответ свеж, если сервер не прислал max-age; 0 - всегда перепроверять
+	TTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// от недавно использованных к давно использованным
+	order *list.List
+	// часы для тестов, nil - time.Now
+	now func() time.Time
+}
+
+type cacheEntry struct {
+	key     string
+	resp    *SearchResponse
+	etag    string
+	expires time.Time
+}
+
+// cacheKey - нормализованный запрос: адрес сервера, токен и параметры.
+// url.Values.Encode сортирует параметры, токен хранится только хэшем.
+func cacheKey(serverURL, token string, params url.Values) string {
+	sum := sha256.Sum256([]byte(token))
+	return serverURL + "\n" + hex.EncodeToString(sum[:]) + "?" + params.Encode()
+}
+
+// get возвращает копию записи и то, свежа ли она
+func (c *ResponseCache) get(key string) (*cacheEntry, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	entry := *elem.Value.(*cacheEntry)
+
+	return &entry, c.clock().Before(entry.expires)
+}
+
+// put запоминает ответ сервера с версией etag, если Cache-Control из header это разрешает.
+// После 304 сюда же попадает прежний ответ, чтобы продлить его свежесть.
+func (c *ResponseCache) put(key string, resp *SearchResponse, etag string, header http.Header) {
+	if c == nil {
+		return
+	}
+	lifetime, store := c.lifetime(header)
+	if !store || lifetime <= 0 && len(etag) == 0 {
+		c.remove(key)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &cacheEntry{key: key, resp: resp.clone(), etag: etag, expires: c.clock().Add(lifetime)}
+	if c.entries == nil {
+		c.entries = make(map[string]*list.Element)
+		c.order = list.New()
+	}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+
+	size := c.Size
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+	for c.order.Len() > size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *ResponseCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+	}
+}
+
+// lifetime читает Cache-Control: сколько ответ свеж и можно ли его хранить вообще
+func (c *ResponseCache) lifetime(header http.Header) (time.Duration, bool) {
+	lifetime := c.TTL
+	noCache := false
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.ToLower(strings.TrimSpace(directive)), "=")
+		switch name {
+		case "no-store":
+			return 0, false
+		case "no-cache":
+			noCache = true
+		case "max-age":
+			if seconds, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil && seconds >= 0 {
+				lifetime = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	if noCache {
+		return 0, true
+	}
+
+	return lifetime, true
+}
+
+func (c *ResponseCache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+
+	return time.Now()
+}
+
+// clone копирует ответ, чтобы вызывающий не испортил кэш
+func (r *SearchResponse) clone() *SearchResponse {
+	result := *r
+	result.Users = slices.Clone(r.Users)
+	result.Highlights = maps.Clone(r.Highlights)
+
+	return &result
+}
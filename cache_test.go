@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cachingServer отвечает как SearchServer, добавляя Cache-Control и ETag, и на совпавший If-None-Match отдает 304
+func cachingServer(cacheControl, etag string) (*httptest.Server, *atomic.Int32, *atomic.Int32) {
+	calls, notModified := &atomic.Int32{}, &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if len(cacheControl) > 0 {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		if len(etag) > 0 {
+			w.Header().Set("ETag", etag)
+			if r.Header.Get("If-None-Match") == etag {
+				notModified.Add(1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+
+		// версией ответа здесь управляет тест, а не SearchServer
+		r.Header.Del("If-None-Match")
+		rr := httptest.NewRecorder()
+		SearchServer(rr, r)
+		for key, values := range rr.Header() {
+			if key != "Etag" || len(etag) == 0 {
+				w.Header()[key] = values
+			}
+		}
+		w.WriteHeader(rr.Code)
+		w.Write(rr.Body.Bytes()) //nolint:errcheck
+	}))
+
+	return server, calls, notModified
+}
+
+func TestFindUsersCache(t *testing.T) {
+	FileDataset = "dataset.xml"
+
+	cases := map[string]struct {
+		CacheControl string
+		ETag         string
+		TTL          time.Duration
+		Advance      time.Duration
+		Calls        int32
+		NotModified  int32
+	}{
+		"fresh by ttl":           {TTL: time.Minute, Advance: 30 * time.Second, Calls: 1},
+		"stale by ttl":           {TTL: time.Minute, Advance: 2 * time.Minute, Calls: 2},
+		"max-age beats ttl":      {CacheControl: "max-age=300", TTL: time.Minute, Advance: 2 * time.Minute, Calls: 1},
+		"no-store":               {CacheControl: "no-store", ETag: `"v1"`, TTL: time.Minute, Calls: 2},
+		"no-cache revalidates":   {CacheControl: "no-cache, max-age=60", ETag: `"v1"`, TTL: time.Minute, Calls: 2, NotModified: 1},
+		"stale etag revalidates": {ETag: `"v1"`, TTL: time.Minute, Advance: 2 * time.Minute, Calls: 2, NotModified: 1},
+		"no ttl nor etag":        {Calls: 2},
+	}
+
+	for name, item := range cases {
+		server, calls, notModified := cachingServer(item.CacheControl, item.ETag)
+		now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+		cache := &ResponseCache{TTL: item.TTL, now: func() time.Time { return now }}
+		client := &SearchClient{AccessToken: "token", URL: server.URL, Cache: cache}
+		req := SearchRequest{Limit: 3, Query: "cillum", OrderField: OrderFieldAge, OrderBy: OrderByAsc}
+
+		first, err := client.FindUsers(req)
+		if err != nil {
+			t.Fatalf("[%s] unexpected error: %v", name, err)
+		}
+		now = now.Add(item.Advance)
+		second, err := client.FindUsers(req)
+		if err != nil {
+			t.Fatalf("[%s] unexpected error: %v", name, err)
+		}
+		server.Close()
+
+		if !reflect.DeepEqual(first, second) {
+			t.Errorf("[%s] cached response differs from original", name)
+		}
+		if calls.Load() != item.Calls || notModified.Load() != item.NotModified {
+			t.Errorf("[%s] wrong server calls: got %d (%d not modified) want %d (%d not modified)",
+				name, calls.Load(), notModified.Load(), item.Calls, item.NotModified)
+		}
+	}
+}
+
+func TestFindUsersCacheKey(t *testing.T) {
+	FileDataset = "dataset.xml"
+
+	server, calls, _ := cachingServer("", "")
+	defer server.Close()
+	cache := &ResponseCache{Size: 2, TTL: time.Minute}
+	client := &SearchClient{AccessToken: "token", URL: server.URL, Cache: cache}
+
+	first, err := client.FindUsers(SearchRequest{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Users[0].Name = "changed by caller"
+
+	steps := []struct {
+		Name    string
+		Token   string
+		Request SearchRequest
+		Calls   int32
+	}{
+		{Name: "same request", Token: "token", Request: SearchRequest{Limit: 2}, Calls: 1},
+		{Name: "other token", Token: "other", Request: SearchRequest{Limit: 2}, Calls: 2},
+		{Name: "errors not cached", Token: "other", Request: SearchRequest{Limit: 2}, Calls: 3},
+		{Name: "other offset", Token: "token", Request: SearchRequest{Limit: 2, Offset: 1}, Calls: 4},
+		{Name: "third request", Token: "token", Request: SearchRequest{Limit: 2, Offset: 2}, Calls: 5},
+		{Name: "least recent evicted", Token: "token", Request: SearchRequest{Limit: 2}, Calls: 6},
+		{Name: "recent kept", Token: "token", Request: SearchRequest{Limit: 2, Offset: 2}, Calls: 6},
+	}
+
+	for _, step := range steps {
+		client.AccessToken = step.Token
+		resp, err := client.FindUsers(step.Request)
+		if step.Token == "token" && err != nil {
+			t.Fatalf("[%s] unexpected error: %v", step.Name, err)
+		}
+		if step.Name == "same request" && resp.Users[0].Name == "changed by caller" {
+			t.Errorf("[%s] caller modified cached response", step.Name)
+		}
+		if calls.Load() != step.Calls {
+			t.Errorf("[%s] wrong server calls: got %d want %d", step.Name, calls.Load(), step.Calls)
+		}
+	}
+}
+
+func TestFindUsersCacheSharedServers(t *testing.T) {
+	FileDataset = "dataset.xml"
+
+	first, firstCalls, _ := cachingServer("", "")
+	defer first.Close()
+	second, secondCalls, _ := cachingServer("", "")
+	defer second.Close()
+
+	cache := &ResponseCache{TTL: time.Minute}
+	clients := []*SearchClient{
+		{AccessToken: "token", URL: first.URL, Cache: cache},
+		{AccessToken: "token", URL: second.URL, Cache: cache},
+	}
+
+	for _, client := range clients {
+		for i := 0; i < 2; i++ {
+			if _, err := client.FindUsers(SearchRequest{Limit: 2}); err != nil {
+				t.Fatalf("[%s] unexpected error: %v", client.URL, err)
+			}
+		}
+	}
+	if firstCalls.Load() != 1 || secondCalls.Load() != 1 {
+		t.Errorf("each server must be asked once: got %d and %d calls", firstCalls.Load(), secondCalls.Load())
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -22,6 +24,8 @@ const (
 var (
 	errTest = errors.New("testing")
 	client  = &http.Client{Timeout: time.Second}
+	// клиент для SearchClient со своим Timeout: время попытки ограничивает контекст, а не http.Client
+	untimedClient = &http.Client{}
 )
 
 type User struct {
@@ -30,11 +34,63 @@ type User struct {
 	Age    int
 	About  string
 	Gender string
+
+	GUID          string
+	IsActive      bool
+	Balance       Money
+	Picture       string
+	EyeColor      string
+	Company       string
+	Email         string
+	Phone         string
+	Address       string
+	Registered    time.Time
+	FavoriteFruit string
+
+	// релевантность запросу, заполняется только при OrderField "relevance"
+	Score float64 `json:",omitempty"`
 }
 
 type SearchResponse struct {
 	Users    []User
 	NextPage bool
+	// курсор следующей страницы для SearchRequest.Cursor, пусто - страниц больше нет.
+	// Исключение - Limit 0: на пустой странице курсора нет, продолжение видно только по NextPage
+	NextCursor string
+	// сколько всего пользователей подходит под запрос, для "страница 3 из 12"
+	Total int
+	// найденные фрагменты по ID пользователя, заполняется при SearchRequest.Highlight
+	Highlights map[int][]Highlight
+}
+
+// Highlight - фрагмент поля Name или About с совпадениями из Query
+type Highlight struct {
+	Field    string
+	Fragment string
+	Matches  []HighlightMatch
+}
+
+// HighlightMatch - совпадение внутри Fragment, смещения в байтах и в рунах, End не включается
+type HighlightMatch struct {
+	Start     int
+	End       int
+	RuneStart int
+	RuneEnd   int
+}
+
+// searchHit - пользователь в ответе сервера вместе с подсветкой
+type searchHit struct {
+	User
+	Highlights []Highlight `json:",omitempty"`
+}
+
+// searchPage - SearchEnvelope, каким его читает клиент
+type searchPage struct {
+	Users  []searchHit
+	Total  int
+	Limit  int
+	Offset int
+	More   bool
 }
 
 type SearchErrorResponse struct {
@@ -47,15 +103,53 @@ const (
 	OrderByDesc = -1
 
 	ErrorBadOrderField = `OrderField invalid`
+
+	// режимы сравнения строк в Query
+	MatchExact = "exact" // побайтно, как есть
+	MatchCI    = "ci"    // без учета регистра
+	MatchFold  = "fold"  // без учета регистра и диакритики: "Jose" найдет "José"
+
+	// правила сравнения строк при сортировке
+	CollationBinary  = "binary"  // побайтно: "Zoe" < "adam"
+	CollationCI      = "ci"      // без учета регистра и диакритики
+	CollationNatural = "natural" // как ci, но числа внутри строк сравниваются как числа: "user2" < "user10"
 )
 
+// QueryError - сервер не смог разобрать Query из запроса
+type QueryError struct {
+	Query string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("Query %q invalid", e.Query)
+}
+
+// SortKey - один ключ сортировки: поле (id, age, name, ...) и OrderByAsc или OrderByDesc
+type SortKey struct {
+	Field   string
+	OrderBy int
+}
+
 type SearchRequest struct {
 	Limit      int
 	Offset     int    // Можно учесть после сортировки
-	Query      string // подстрока в 1 из полей
+	Query      string // подстрока в 1 из полей или выражение вида `name:boyd AND age:>30`
 	OrderField string
 	//  1 по возрастанию, 0 как встретилось, -1 по убыванию
 	OrderBy int
+	// поля пользователя, которые нужно вернуть (id, name, age, ...), пусто - все поля
+	Fields []string
+	// MatchExact, MatchCI или MatchFold, пусто - MatchExact
+	MatchMode string
+	// вернуть в SearchResponse.Highlights фрагменты с совпадениями
+	Highlight bool
+	// сортировка по нескольким ключам, если задана - OrderField и OrderBy не учитываются
+	Sort []SortKey
+	// CollationBinary, CollationCI или CollationNatural, пусто - CollationBinary
+	Collation string
+	// SearchResponse.NextCursor предыдущей страницы: выдача продолжится строго после ее последней строки,
+	// даже если между запросами в данных появились новые строки. Offset отсчитывается от курсора.
+	Cursor string
 }
 
 type SearchClient struct {
@@ -63,75 +157,221 @@ type SearchClient struct {
 	AccessToken string
 	// урл внешней системы, куда идти
 	URL string
+	// клиент для запросов, nil - общий клиент пакета (с таймаутом в секунду, если не задан Timeout)
+	HTTPClient *http.Client
+	// ограничение на одну попытку запроса, 0 - таймаут HTTPClient или секунда по умолчанию.
+	// Заданный HTTPClient со своим Timeout может оборвать попытку и раньше
+	Timeout time.Duration
+	// повторы при сетевых ошибках и временных отказах сервера, nil - без повторов
+	Retry *RetryPolicy
+	// быстрый отказ с ErrCircuitOpen, пока сервер лежит, nil - без него
+	Breaker *CircuitBreaker
+	// кэш ответов, nil - без кэша
+	Cache *ResponseCache
 }
 
 // FindUsers отправляет запрос во внешнюю систему, которая непосредственно ищет пользователей
 func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
+	return srv.FindUsersContext(context.Background(), req)
+}
 
+// FindUsersContext - FindUsers, который прерывается при отмене ctx.
+// Ошибка по таймауту оборачивает context.DeadlineExceeded.
+func (srv *SearchClient) FindUsersContext(ctx context.Context, req SearchRequest) (*SearchResponse, error) {
 	searcherParams := url.Values{}
 
 	if req.Limit < 0 {
-		return nil, fmt.Errorf("limit must be > 0")
+		return nil, fmt.Errorf("%w: limit must be >= 0", ErrBadLimit)
 	}
-	if req.Limit > 25 {
-		req.Limit = 25
+	if req.Limit > MaxLimit {
+		req.Limit = MaxLimit
 	}
 	if req.Offset < 0 {
-		return nil, fmt.Errorf("offset must be > 0")
+		return nil, fmt.Errorf("%w: offset must be >= 0", ErrBadOffset)
+	}
+	order := make([]string, 0, len(req.Sort))
+	for _, key := range req.Sort {
+		switch key.OrderBy {
+		case OrderByAsc:
+			order = append(order, key.Field+":asc")
+		case OrderByDesc:
+			order = append(order, key.Field+":desc")
+		default:
+			return nil, fmt.Errorf("%w: sort order for %s must be asc or desc", ErrBadOrderBy, key.Field)
+		}
 	}
-
-	// нужно для получения следующей записи, на основе которой мы скажем - можно показать переключатель следующей страницы или нет
-	req.Limit++
 
 	searcherParams.Add("limit", strconv.Itoa(req.Limit))
 	searcherParams.Add("offset", strconv.Itoa(req.Offset))
 	searcherParams.Add("query", req.Query)
 	searcherParams.Add("order_field", req.OrderField)
 	searcherParams.Add("order_by", strconv.Itoa(req.OrderBy))
+	searcherParams.Add("version", strconv.Itoa(ResponseVersion2))
+	if len(req.Fields) > 0 {
+		searcherParams.Add("fields", strings.Join(req.Fields, ","))
+	}
+	if len(req.MatchMode) > 0 {
+		searcherParams.Add("match", req.MatchMode)
+	}
+	if req.Highlight {
+		searcherParams.Add("highlight", "true")
+	}
+	if len(order) > 0 {
+		searcherParams.Add("order", strings.Join(order, ","))
+	}
+	if len(req.Collation) > 0 {
+		searcherParams.Add("collation", req.Collation)
+	}
+	if len(req.Cursor) > 0 {
+		searcherParams.Add("cursor", req.Cursor)
+	}
 
-	searcherReq, _ := http.NewRequest("GET", srv.URL+"?"+searcherParams.Encode(), nil) //nolint:errcheck
-	searcherReq.Header.Add("AccessToken", srv.AccessToken)
+	key := cacheKey(srv.URL, srv.AccessToken, searcherParams)
+	cached, fresh := srv.Cache.get(key)
+	if fresh {
+		return cached.resp.clone(), nil
+	}
+	etag := ""
+	if cached != nil {
+		etag = cached.etag
+	}
 
-	resp, err := client.Do(searcherReq)
+	if err := srv.Breaker.allow(); err != nil {
+		return nil, err
+	}
+
+	var resp *http.Response
+	var body []byte
+	var err error
+	for attempt := 1; ; attempt++ {
+		resp, body, err = srv.do(ctx, searcherParams, etag)
+		delay, retry := srv.Retry.next(ctx, attempt, resp, err)
+		if !retry {
+			break
+		}
+		if err = sleepContext(ctx, delay); err != nil {
+			break
+		}
+	}
+	srv.Breaker.record(outcomeOf(resp, err))
 	if err != nil {
-		if err, ok := err.(net.Error); ok && err.Timeout() {
-			return nil, fmt.Errorf("timeout for %s", searcherParams.Encode())
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() || errors.Is(err, context.DeadlineExceeded) {
+			return nil, fmt.Errorf("timeout for %s: %w", searcherParams.Encode(), context.DeadlineExceeded)
 		}
-		return nil, fmt.Errorf("unknown error %s", err)
+		return nil, fmt.Errorf("%w: %w", ErrRequestFailed, err)
 	}
-	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body) //nolint:errcheck
 
 	switch resp.StatusCode {
+	case http.StatusNotModified:
+		if cached != nil {
+			if newTag := resp.Header.Get("ETag"); len(newTag) > 0 {
+				etag = newTag
+			}
+			srv.Cache.put(key, cached.resp, etag, resp.Header)
+			return cached.resp.clone(), nil
+		}
+		return nil, unexpectedStatusError(resp.StatusCode, body, searcherParams)
 	case http.StatusUnauthorized:
-		return nil, fmt.Errorf("bad AccessToken")
+		// тело с кодом есть не у всех серверов, без него Code остается пустым
+		errResp := SearchErrorResponse{}
+		json.Unmarshal(body, &errResp) //nolint:errcheck
+		return nil, &APIError{StatusCode: resp.StatusCode, Code: errResp.Error, Params: searcherParams, err: ErrBadAccessToken, message: ErrBadAccessToken.Error()}
 	case http.StatusInternalServerError:
-		return nil, fmt.Errorf("SearchServer fatal error")
+		return nil, &APIError{StatusCode: resp.StatusCode, Params: searcherParams, err: ErrServerFatal, message: ErrServerFatal.Error()}
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return nil, &APIError{StatusCode: resp.StatusCode, Params: searcherParams, err: ErrServerUnavailable, message: ErrServerUnavailable.Error()}
+	case http.StatusTooManyRequests:
+		return nil, &APIError{StatusCode: resp.StatusCode, Params: searcherParams, err: ErrTooManyRequests, message: ErrTooManyRequests.Error()}
 	case http.StatusBadRequest:
 		errResp := SearchErrorResponse{}
 		err = json.Unmarshal(body, &errResp)
 		if err != nil {
-			return nil, fmt.Errorf("cant unpack error json: %s", err)
-		}
-		if errResp.Error == ErrorBadOrderField {
-			return nil, fmt.Errorf("OrderFeld %s invalid", req.OrderField)
+			return nil, fmt.Errorf("%w: cant unpack error json: %w", ErrBadResponse, err)
 		}
-		return nil, fmt.Errorf("unknown bad request error: %s", errResp.Error)
+		return nil, badRequestError(errResp.Error, req, order, searcherParams)
+	case http.StatusOK:
+	default:
+		return nil, unexpectedStatusError(resp.StatusCode, body, searcherParams)
 	}
 
-	data := []User{}
-	err = json.Unmarshal(body, &data)
+	page := searchPage{}
+	err = json.Unmarshal(body, &page)
 	if err != nil {
-		return nil, fmt.Errorf("cant unpack result json: %s", err)
+		return nil, fmt.Errorf("%w: cant unpack result json: %w", ErrBadResponse, err)
 	}
 
-	result := SearchResponse{}
-	if len(data) == req.Limit {
-		result.NextPage = true
-		result.Users = data[0 : len(data)-1]
-	} else {
-		result.Users = data[0:]
+	// курсор сервер отдает, только если за страницей есть еще строки, но на пустой странице (Limit 0)
+	// ставить его некуда - тогда о продолжении говорит только More
+	result := SearchResponse{NextCursor: resp.Header.Get(HeaderNextCursor), Total: page.Total}
+	result.NextPage = page.More || len(result.NextCursor) > 0
+	result.Users = make([]User, 0, len(page.Users))
+	for _, hit := range page.Users {
+		result.Users = append(result.Users, hit.User)
+		if len(hit.Highlights) > 0 {
+			if result.Highlights == nil {
+				result.Highlights = make(map[int][]Highlight)
+			}
+			result.Highlights[hit.ID] = hit.Highlights
+		}
 	}
+	srv.Cache.put(key, &result, resp.Header.Get("ETag"), resp.Header)
 
 	return &result, err
 }
+
+// do выполняет одну попытку запроса и читает ответ целиком.
+// Непустой etag делает запрос условным: если ответ не изменился, сервер вернет 304.
+func (srv *SearchClient) do(ctx context.Context, params url.Values, etag string) (*http.Response, []byte, error) {
+	if srv.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, srv.Timeout)
+		defer cancel()
+	}
+	httpClient := srv.HTTPClient
+	switch {
+	case httpClient != nil:
+	case srv.Timeout > 0:
+		httpClient = untimedClient
+	default:
+		httpClient = client
+	}
+
+	searcherReq, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"?"+params.Encode(), nil) //nolint:errcheck
+	searcherReq.Header.Add("AccessToken", srv.AccessToken)
+	if len(etag) > 0 {
+		searcherReq.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := httpClient.Do(searcherReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return resp, body, nil
+}
+
+// EachPage проходит по всем страницам выдачи через курсоры и вызывает fn для каждой.
+// req задает первую страницу; ошибка из fn прерывает обход и возвращается как есть.
+func (srv *SearchClient) EachPage(req SearchRequest, fn func(page *SearchResponse) error) error {
+	for {
+		page, err := srv.FindUsers(req)
+		if err != nil {
+			return err
+		}
+		if err = fn(page); err != nil {
+			return err
+		}
+		if !page.NextPage {
+			return nil
+		}
+
+		req.Cursor = page.NextCursor
+		req.Offset = 0
+	}
+}
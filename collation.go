@@ -0,0 +1,101 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// collator сравнивает строковые поля при сортировке
+type collator func(a, b string) int
+
+func newCollator(collation string) (collator, error) {
+	switch collation {
+	case CollationBinary, "":
+		return strings.Compare, nil
+	case CollationCI:
+		return func(a, b string) int { return compareCollated(a, b, false) }, nil
+	case CollationNatural:
+		return func(a, b string) int { return compareCollated(a, b, true) }, nil
+	}
+
+	return nil, fmt.Errorf(ErrorBadCollation)
+}
+
+// compareCollated сравнивает строки без учета регистра и диакритики, не выделяя память.
+// В режиме natural числа внутри строк сравниваются как числа: "user2" < "user10".
+func compareCollated(a, b string, natural bool) int {
+	for len(a) > 0 && len(b) > 0 {
+		if natural && isASCIIDigit(a[0]) && isASCIIDigit(b[0]) {
+			var x, y string
+			x, a = digitRun(a)
+			y, b = digitRun(b)
+			if c := compareNumbers(x, y); c != 0 {
+				return c
+			}
+			continue
+		}
+
+		r1, n1 := utf8.DecodeRuneInString(a)
+		r2, n2 := utf8.DecodeRuneInString(b)
+		f1, f2 := lowerFoldRune(r1), lowerFoldRune(r2)
+		if f1 < 0 {
+			a = a[n1:]
+			continue
+		}
+		if f2 < 0 {
+			b = b[n2:]
+			continue
+		}
+		if f1 != f2 {
+			return cmp.Compare(f1, f2)
+		}
+		a, b = a[n1:], b[n2:]
+	}
+
+	return cmp.Compare(visibleLen(a), visibleLen(b))
+}
+
+// visibleLen - длина строки без комбинируемых знаков, которые сравнение пропускает
+func visibleLen(s string) int {
+	n := 0
+	for _, r := range s {
+		if !unicode.Is(unicode.Mn, r) {
+			n++
+		}
+	}
+
+	return n
+}
+
+func isASCIIDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// digitRun отделяет число в начале s от остатка строки
+func digitRun(s string) (string, string) {
+	i := 0
+	for i < len(s) && isASCIIDigit(s[i]) {
+		i++
+	}
+
+	return s[:i], s[i:]
+}
+
+// compareNumbers сравнивает записи неотрицательных чисел любой длины
+func compareNumbers(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if c := cmp.Compare(len(a), len(b)); c != 0 {
+		return c
+	}
+
+	return strings.Compare(a, b)
+}
+
+func parseCollationParam(r *http.Request) (collator, error) {
+	return newCollator(r.URL.Query().Get("collation"))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSortUsersCollation(t *testing.T) {
+	users := Users{
+		{ID: 1, Name: "Zoe"},
+		{ID: 2, Name: "adam"},
+		{ID: 3, Name: "Émile"},
+		{ID: 4, Name: "user10"},
+		{ID: 5, Name: "Adam"},
+		{ID: 6, Name: "user2"},
+		{ID: 7, Name: "Emile"},
+		{ID: 8, Name: "user02"},
+	}
+
+	cases := map[string]struct {
+		Collation string
+		OrderBy   int
+		IDs       []int
+	}{
+		"binary":       {Collation: CollationBinary, OrderBy: OrderByAsc, IDs: []int{5, 7, 1, 2, 8, 4, 6, 3}},
+		"ci":           {Collation: CollationCI, OrderBy: OrderByAsc, IDs: []int{2, 5, 3, 7, 8, 4, 6, 1}},
+		"natural":      {Collation: CollationNatural, OrderBy: OrderByAsc, IDs: []int{2, 5, 3, 7, 6, 8, 4, 1}},
+		"natural desc": {Collation: CollationNatural, OrderBy: OrderByDesc, IDs: []int{1, 4, 6, 8, 3, 7, 2, 5}},
+	}
+
+	for name, item := range cases {
+		collate, err := newCollator(item.Collation)
+		if err != nil {
+			t.Fatalf("[%s] unexpected error: %v", name, err)
+		}
+
+		sorted := sortUsers(append(Users{}, users...), []sortKey{{field: OrderFieldName, orderBy: item.OrderBy}}, collate)
+		ids := []int{}
+		for _, user := range sorted {
+			ids = append(ids, user.ID)
+		}
+		if !reflect.DeepEqual(item.IDs, ids) {
+			t.Errorf("[%s] wrong order, expected %v, got %v", name, item.IDs, ids)
+		}
+	}
+
+	if _, err := newCollator("locale"); err == nil {
+		t.Error("expected error for unknown collation, got nil")
+	}
+}
@@ -1,12 +1,21 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestSearchServerParseLimitParam(t *testing.T) {
@@ -51,6 +60,114 @@ func TestSearchServerParseOffsetParam(t *testing.T) {
 	}
 }
 
+func TestSearchServerMaxLimit(t *testing.T) {
+	FileDataset = "dataset.xml"
+
+	cases := map[string]struct {
+		Limit   int
+		Version int
+		Status  int
+	}{
+		"max":                {Limit: MaxLimit, Version: ResponseVersion2, Status: http.StatusOK},
+		"above max":          {Limit: MaxLimit + 1, Version: ResponseVersion2, Status: http.StatusBadRequest},
+		"legacy limit+1":     {Limit: MaxLimit + 1, Version: ResponseVersion1, Status: http.StatusOK},
+		"legacy above limit": {Limit: MaxLimit + 2, Version: ResponseVersion1, Status: http.StatusBadRequest},
+		"far too big":        {Limit: 1000, Version: ResponseVersion1, Status: http.StatusBadRequest},
+	}
+
+	for name, item := range cases {
+		params := url.Values{}
+		params.Add("limit", strconv.Itoa(item.Limit))
+		params.Add("offset", "0")
+		params.Add("order_by", "0")
+		params.Add("version", strconv.Itoa(item.Version))
+
+		req, err := http.NewRequest("GET", "/?"+params.Encode(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("AccessToken", "token")
+
+		rr := httptest.NewRecorder()
+		SearchServer(rr, req)
+
+		if status := rr.Code; status != item.Status {
+			t.Errorf("[%s] wrong status code: got %v want %v", name, status, item.Status)
+		}
+		if item.Status == http.StatusBadRequest && !strings.Contains(rr.Body.String(), ErrorBadLimit) {
+			t.Errorf("[%s] wrong error: %s", name, rr.Body.String())
+		}
+		if item.Version == ResponseVersion1 && item.Status == http.StatusOK {
+			users := []User{}
+			if err := json.Unmarshal(rr.Body.Bytes(), &users); err != nil || len(users) != item.Limit {
+				t.Errorf("[%s] legacy client must get %d rows: got %d, %v", name, item.Limit, len(users), err)
+			}
+		}
+	}
+}
+
+func TestLimitOffsetUsers(t *testing.T) {
+	users := Users{{ID: 1}, {ID: 2}, {ID: 3}}
+
+	cases := map[string]struct {
+		Limit  int
+		Offset int
+		IDs    []int
+	}{
+		"first page":      {Limit: 2, Offset: 0, IDs: []int{1, 2}},
+		"last page":       {Limit: 2, Offset: 2, IDs: []int{3}},
+		"offset at end":   {Limit: 2, Offset: 3, IDs: []int{}},
+		"offset past end": {Limit: 2, Offset: 1000, IDs: []int{}},
+		"zero limit":      {Limit: 0, Offset: 1, IDs: []int{}},
+	}
+
+	for name, item := range cases {
+		ids := []int{}
+		for _, user := range limitOffsetUsers(users, item.Limit, item.Offset) {
+			ids = append(ids, user.ID)
+		}
+		if !reflect.DeepEqual(item.IDs, ids) {
+			t.Errorf("[%s] wrong page, expected %v, got %v", name, item.IDs, ids)
+		}
+	}
+}
+
+func TestSearchServerParseOrderParam(t *testing.T) {
+	FileDataset = "dataset.xml"
+
+	cases := map[string]struct {
+		Order  string
+		Status int
+	}{
+		"valid":             {Order: "age:desc,name:asc,id", Status: http.StatusOK},
+		"unknown field":     {Order: "age:desc,password", Status: http.StatusBadRequest},
+		"empty key":         {Order: "age:desc,,id", Status: http.StatusBadRequest},
+		"unknown direction": {Order: "age:sideways", Status: http.StatusBadRequest},
+		"dataset column":    {Order: "company,about_length:desc", Status: http.StatusOK},
+	}
+
+	for name, item := range cases {
+		params := url.Values{}
+		params.Add("limit", "1")
+		params.Add("offset", "0")
+		params.Add("order_by", "0")
+		params.Add("order", item.Order)
+
+		req, err := http.NewRequest("GET", "/?"+params.Encode(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("AccessToken", "token")
+
+		rr := httptest.NewRecorder()
+		SearchServer(rr, req)
+
+		if status := rr.Code; status != item.Status {
+			t.Errorf("[%s] wrong status code: got %v want %v", name, status, item.Status)
+		}
+	}
+}
+
 func TestFindUsers(t *testing.T) {
 	cases := map[string]struct {
 		DatasetName string
@@ -73,21 +190,44 @@ func TestFindUsers(t *testing.T) {
 			Response: &SearchResponse{
 				Users: []User{
 					{
-						ID:     0,
-						Name:   "Boyd Wolf",
-						Age:    22,
-						About:  "Nulla cillum enim voluptate consequat laborum esse excepteur occaecat commodo nostrud excepteur ut cupidatat. Occaecat minim incididunt ut proident ad sint nostrud ad laborum sint pariatur. Ut nulla commodo dolore officia. Consequat anim eiusmod amet commodo eiusmod deserunt culpa. Ea sit dolore nostrud cillum proident nisi mollit est Lorem pariatur. Lorem aute officia deserunt dolor nisi aliqua consequat nulla nostrud ipsum irure id deserunt dolore. Minim reprehenderit nulla exercitation labore ipsum.\n",
-						Gender: "male",
+						ID:            0,
+						Name:          "Boyd Wolf",
+						Age:           22,
+						About:         "Nulla cillum enim voluptate consequat laborum esse excepteur occaecat commodo nostrud excepteur ut cupidatat. Occaecat minim incididunt ut proident ad sint nostrud ad laborum sint pariatur. Ut nulla commodo dolore officia. Consequat anim eiusmod amet commodo eiusmod deserunt culpa. Ea sit dolore nostrud cillum proident nisi mollit est Lorem pariatur. Lorem aute officia deserunt dolor nisi aliqua consequat nulla nostrud ipsum irure id deserunt dolore. Minim reprehenderit nulla exercitation labore ipsum.\n",
+						Gender:        "male",
+						GUID:          "1a6fa827-62f1-45f6-b579-aaead2b47169",
+						IsActive:      false,
+						Balance:       Money(214493),
+						Picture:       "http://placehold.it/32x32",
+						EyeColor:      "green",
+						Company:       "HOPELI",
+						Email:         "boydwolf@hopeli.com",
+						Phone:         "+1 (956) 593-2402",
+						Address:       "586 Winthrop Street, Edneyville, Mississippi, 9555",
+						Registered:    time.Date(2017, 2, 5, 6, 23, 27, 0, time.FixedZone("", -3*60*60)),
+						FavoriteFruit: "apple",
 					},
 					{
-						ID:     2,
-						Name:   "Brooks Aguilar",
-						Age:    25,
-						About:  "Velit ullamco est aliqua voluptate nisi do. Voluptate magna anim qui cillum aliqua sint veniam reprehenderit consectetur enim. Laborum dolore ut eiusmod ipsum ad anim est do tempor culpa ad do tempor. Nulla id aliqua dolore dolore adipisicing.\n",
-						Gender: "male",
+						ID:            2,
+						Name:          "Brooks Aguilar",
+						Age:           25,
+						About:         "Velit ullamco est aliqua voluptate nisi do. Voluptate magna anim qui cillum aliqua sint veniam reprehenderit consectetur enim. Laborum dolore ut eiusmod ipsum ad anim est do tempor culpa ad do tempor. Nulla id aliqua dolore dolore adipisicing.\n",
+						Gender:        "male",
+						GUID:          "0601af31-061f-4249-988d-32027a545b85",
+						IsActive:      false,
+						Balance:       Money(104764),
+						Picture:       "http://placehold.it/32x32",
+						EyeColor:      "blue",
+						Company:       "ZILLACOM",
+						Email:         "brooksaguilar@zillacom.com",
+						Phone:         "+1 (924) 416-3150",
+						Address:       "806 Williams Court, Vandiver, North Carolina, 9205",
+						Registered:    time.Date(2016, 9, 5, 6, 52, 19, 0, time.FixedZone("", -3*60*60)),
+						FavoriteFruit: "strawberry",
 					},
 				},
 				NextPage: true,
+				Total:    18,
 			},
 			IsError: false,
 		},
@@ -104,21 +244,44 @@ func TestFindUsers(t *testing.T) {
 			Response: &SearchResponse{
 				Users: []User{
 					{
-						ID:     20,
-						Name:   "Lowery York",
-						Age:    27,
-						About:  "Dolor enim sit id dolore enim sint nostrud deserunt. Occaecat minim enim veniam proident mollit Lorem irure ex. Adipisicing pariatur adipisicing aliqua amet proident velit. Magna commodo culpa sit id.\n",
-						Gender: "male",
+						ID:            20,
+						Name:          "Lowery York",
+						Age:           27,
+						About:         "Dolor enim sit id dolore enim sint nostrud deserunt. Occaecat minim enim veniam proident mollit Lorem irure ex. Adipisicing pariatur adipisicing aliqua amet proident velit. Magna commodo culpa sit id.\n",
+						Gender:        "male",
+						GUID:          "036b4c27-ca15-4100-a5f0-f27e5e6ab984",
+						IsActive:      true,
+						Balance:       Money(300327),
+						Picture:       "http://placehold.it/32x32",
+						EyeColor:      "blue",
+						Company:       "COMCUR",
+						Email:         "loweryyork@comcur.com",
+						Phone:         "+1 (853) 406-2484",
+						Address:       "523 Banker Street, Monument, Colorado, 6831",
+						Registered:    time.Date(2016, 7, 3, 12, 1, 4, 0, time.FixedZone("", -3*60*60)),
+						FavoriteFruit: "apple",
 					},
 					{
-						ID:     17,
-						Name:   "Dillard Mccoy",
-						Age:    36,
-						About:  "Laborum voluptate sit ipsum tempor dolore. Adipisicing reprehenderit minim aliqua est. Consectetur enim deserunt incididunt elit non consectetur nisi esse ut dolore officia do ipsum.\n",
-						Gender: "male",
+						ID:            17,
+						Name:          "Dillard Mccoy",
+						Age:           36,
+						About:         "Laborum voluptate sit ipsum tempor dolore. Adipisicing reprehenderit minim aliqua est. Consectetur enim deserunt incididunt elit non consectetur nisi esse ut dolore officia do ipsum.\n",
+						Gender:        "male",
+						GUID:          "eea70a1a-ffd5-4ce2-8a35-34c54e2092e8",
+						IsActive:      false,
+						Balance:       Money(331478),
+						Picture:       "http://placehold.it/32x32",
+						EyeColor:      "green",
+						Company:       "ISOSURE",
+						Email:         "dillardmccoy@isosure.com",
+						Phone:         "+1 (805) 516-2041",
+						Address:       "857 Brighton Avenue, Turpin, Alaska, 2798",
+						Registered:    time.Date(2015, 8, 20, 12, 7, 28, 0, time.FixedZone("", -3*60*60)),
+						FavoriteFruit: "banana",
 					},
 				},
 				NextPage: false,
+				Total:    3,
 			},
 			IsError: false,
 		},
@@ -204,21 +367,44 @@ func TestFindUsers(t *testing.T) {
 			Response: &SearchResponse{
 				Users: []User{
 					{
-						ID:     15,
-						Name:   "Allison Valdez",
-						Age:    21,
-						About:  "Labore excepteur voluptate velit occaecat est nisi minim. Laborum ea et irure nostrud enim sit incididunt reprehenderit id est nostrud eu. Ullamco sint nisi voluptate cillum nostrud aliquip et minim. Enim duis esse do aute qui officia ipsum ut occaecat deserunt. Pariatur pariatur nisi do ad dolore reprehenderit et et enim esse dolor qui. Excepteur ullamco adipisicing qui adipisicing tempor minim aliquip.\n",
-						Gender: "male",
+						ID:            15,
+						Name:          "Allison Valdez",
+						Age:           21,
+						About:         "Labore excepteur voluptate velit occaecat est nisi minim. Laborum ea et irure nostrud enim sit incididunt reprehenderit id est nostrud eu. Ullamco sint nisi voluptate cillum nostrud aliquip et minim. Enim duis esse do aute qui officia ipsum ut occaecat deserunt. Pariatur pariatur nisi do ad dolore reprehenderit et et enim esse dolor qui. Excepteur ullamco adipisicing qui adipisicing tempor minim aliquip.\n",
+						Gender:        "male",
+						GUID:          "a281d387-df24-4c8a-9a08-19588749d95e",
+						IsActive:      false,
+						Balance:       Money(138916),
+						Picture:       "http://placehold.it/32x32",
+						EyeColor:      "green",
+						Company:       "XUMONK",
+						Email:         "allisonvaldez@xumonk.com",
+						Phone:         "+1 (822) 430-2401",
+						Address:       "436 Independence Avenue, Castleton, New Mexico, 7874",
+						Registered:    time.Date(2014, 10, 13, 2, 21, 35, 0, time.FixedZone("", -3*60*60)),
+						FavoriteFruit: "banana",
 					},
 					{
-						ID:     16,
-						Name:   "Annie Osborn",
-						Age:    35,
-						About:  "Consequat fugiat veniam commodo nisi nostrud culpa pariatur. Aliquip velit adipisicing dolor et nostrud. Eu nostrud officia velit eiusmod ullamco duis eiusmod ad non do quis.\n",
-						Gender: "female",
+						ID:            16,
+						Name:          "Annie Osborn",
+						Age:           35,
+						About:         "Consequat fugiat veniam commodo nisi nostrud culpa pariatur. Aliquip velit adipisicing dolor et nostrud. Eu nostrud officia velit eiusmod ullamco duis eiusmod ad non do quis.\n",
+						Gender:        "female",
+						GUID:          "143317ac-d310-469c-a423-717e8ce85cd8",
+						IsActive:      true,
+						Balance:       Money(367771),
+						Picture:       "http://placehold.it/32x32",
+						EyeColor:      "brown",
+						Company:       "PHARMEX",
+						Email:         "annieosborn@pharmex.com",
+						Phone:         "+1 (904) 409-3942",
+						Address:       "288 Albany Avenue, Hiko, Maine, 954",
+						Registered:    time.Date(2015, 10, 27, 3, 54, 56, 0, time.FixedZone("", -3*60*60)),
+						FavoriteFruit: "apple",
 					},
 				},
 				NextPage: true,
+				Total:    35,
 			},
 			IsError: false,
 		},
@@ -234,21 +420,44 @@ func TestFindUsers(t *testing.T) {
 			Response: &SearchResponse{
 				Users: []User{
 					{
-						ID:     13,
-						Name:   "Whitley Davidson",
-						Age:    40,
-						About:  "Consectetur dolore anim veniam aliqua deserunt officia eu. Et ullamco commodo ad officia duis ex incididunt proident consequat nostrud proident quis tempor. Sunt magna ad excepteur eu sint aliqua eiusmod deserunt proident. Do labore est dolore voluptate ullamco est dolore excepteur magna duis quis. Quis laborum deserunt ipsum velit occaecat est laborum enim aute. Officia dolore sit voluptate quis mollit veniam. Laborum nisi ullamco nisi sit nulla cillum et id nisi.\n",
-						Gender: "male",
+						ID:            13,
+						Name:          "Whitley Davidson",
+						Age:           40,
+						About:         "Consectetur dolore anim veniam aliqua deserunt officia eu. Et ullamco commodo ad officia duis ex incididunt proident consequat nostrud proident quis tempor. Sunt magna ad excepteur eu sint aliqua eiusmod deserunt proident. Do labore est dolore voluptate ullamco est dolore excepteur magna duis quis. Quis laborum deserunt ipsum velit occaecat est laborum enim aute. Officia dolore sit voluptate quis mollit veniam. Laborum nisi ullamco nisi sit nulla cillum et id nisi.\n",
+						Gender:        "male",
+						GUID:          "5b3cc38a-e78d-4e51-8deb-a943be9f02ab",
+						IsActive:      true,
+						Balance:       Money(111905),
+						Picture:       "http://placehold.it/32x32",
+						EyeColor:      "blue",
+						Company:       "COMTEXT",
+						Email:         "whitleydavidson@comtext.com",
+						Phone:         "+1 (839) 565-2229",
+						Address:       "198 Chestnut Street, Cliffside, Palau, 4383",
+						Registered:    time.Date(2014, 6, 22, 6, 4, 41, 0, time.FixedZone("", -3*60*60)),
+						FavoriteFruit: "banana",
 					},
 					{
-						ID:     32,
-						Name:   "Christy Knapp",
-						Age:    40,
-						About:  "Incididunt culpa dolore laborum cupidatat consequat. Aliquip cupidatat pariatur sit consectetur laboris labore anim labore. Est sint ut ipsum dolor ipsum nisi tempor in tempor aliqua. Aliquip labore cillum est consequat anim officia non reprehenderit ex duis elit. Amet aliqua eu ad velit incididunt ad ut magna. Culpa dolore qui anim consequat commodo aute.\n",
-						Gender: "female",
+						ID:            32,
+						Name:          "Christy Knapp",
+						Age:           40,
+						About:         "Incididunt culpa dolore laborum cupidatat consequat. Aliquip cupidatat pariatur sit consectetur laboris labore anim labore. Est sint ut ipsum dolor ipsum nisi tempor in tempor aliqua. Aliquip labore cillum est consequat anim officia non reprehenderit ex duis elit. Amet aliqua eu ad velit incididunt ad ut magna. Culpa dolore qui anim consequat commodo aute.\n",
+						Gender:        "female",
+						GUID:          "49c236e7-89e5-4e9f-94cd-9f7aabf914dc",
+						IsActive:      true,
+						Balance:       Money(365951),
+						Picture:       "http://placehold.it/32x32",
+						EyeColor:      "blue",
+						Company:       "XANIDE",
+						Email:         "christyknapp@xanide.com",
+						Phone:         "+1 (825) 499-3933",
+						Address:       "914 Porter Avenue, Kirk, Massachusetts, 9088",
+						Registered:    time.Date(2016, 12, 21, 5, 48, 37, 0, time.FixedZone("", -3*60*60)),
+						FavoriteFruit: "strawberry",
 					},
 				},
 				NextPage: true,
+				Total:    35,
 			},
 			IsError: false,
 		},
@@ -264,21 +473,44 @@ func TestFindUsers(t *testing.T) {
 			Response: &SearchResponse{
 				Users: []User{
 					{
-						ID:     0,
-						Name:   "Boyd Wolf",
-						Age:    22,
-						About:  "Nulla cillum enim voluptate consequat laborum esse excepteur occaecat commodo nostrud excepteur ut cupidatat. Occaecat minim incididunt ut proident ad sint nostrud ad laborum sint pariatur. Ut nulla commodo dolore officia. Consequat anim eiusmod amet commodo eiusmod deserunt culpa. Ea sit dolore nostrud cillum proident nisi mollit est Lorem pariatur. Lorem aute officia deserunt dolor nisi aliqua consequat nulla nostrud ipsum irure id deserunt dolore. Minim reprehenderit nulla exercitation labore ipsum.\n",
-						Gender: "male",
+						ID:            0,
+						Name:          "Boyd Wolf",
+						Age:           22,
+						About:         "Nulla cillum enim voluptate consequat laborum esse excepteur occaecat commodo nostrud excepteur ut cupidatat. Occaecat minim incididunt ut proident ad sint nostrud ad laborum sint pariatur. Ut nulla commodo dolore officia. Consequat anim eiusmod amet commodo eiusmod deserunt culpa. Ea sit dolore nostrud cillum proident nisi mollit est Lorem pariatur. Lorem aute officia deserunt dolor nisi aliqua consequat nulla nostrud ipsum irure id deserunt dolore. Minim reprehenderit nulla exercitation labore ipsum.\n",
+						Gender:        "male",
+						GUID:          "1a6fa827-62f1-45f6-b579-aaead2b47169",
+						IsActive:      false,
+						Balance:       Money(214493),
+						Picture:       "http://placehold.it/32x32",
+						EyeColor:      "green",
+						Company:       "HOPELI",
+						Email:         "boydwolf@hopeli.com",
+						Phone:         "+1 (956) 593-2402",
+						Address:       "586 Winthrop Street, Edneyville, Mississippi, 9555",
+						Registered:    time.Date(2017, 2, 5, 6, 23, 27, 0, time.FixedZone("", -3*60*60)),
+						FavoriteFruit: "apple",
 					},
 					{
-						ID:     1,
-						Name:   "Hilda Mayer",
-						Age:    21,
-						About:  "Sit commodo consectetur minim amet ex. Elit aute mollit fugiat labore sint ipsum dolor cupidatat qui reprehenderit. Eu nisi in exercitation culpa sint aliqua nulla nulla proident eu. Nisi reprehenderit anim cupidatat dolor incididunt laboris mollit magna commodo ex. Cupidatat sit id aliqua amet nisi et voluptate voluptate commodo ex eiusmod et nulla velit.\n",
-						Gender: "female",
+						ID:            1,
+						Name:          "Hilda Mayer",
+						Age:           21,
+						About:         "Sit commodo consectetur minim amet ex. Elit aute mollit fugiat labore sint ipsum dolor cupidatat qui reprehenderit. Eu nisi in exercitation culpa sint aliqua nulla nulla proident eu. Nisi reprehenderit anim cupidatat dolor incididunt laboris mollit magna commodo ex. Cupidatat sit id aliqua amet nisi et voluptate voluptate commodo ex eiusmod et nulla velit.\n",
+						Gender:        "female",
+						GUID:          "46c06b5e-dd08-4e26-bf85-b15d280e5e07",
+						IsActive:      false,
+						Balance:       Money(270571),
+						Picture:       "http://placehold.it/32x32",
+						EyeColor:      "green",
+						Company:       "QUINTITY",
+						Email:         "hildamayer@quintity.com",
+						Phone:         "+1 (932) 421-2117",
+						Address:       "311 Friel Place, Loyalhanna, Kansas, 6845",
+						Registered:    time.Date(2016, 11, 20, 4, 40, 7, 0, time.FixedZone("", -3*60*60)),
+						FavoriteFruit: "banana",
 					},
 				},
 				NextPage: true,
+				Total:    35,
 			},
 			IsError: false,
 		},
@@ -294,21 +526,44 @@ func TestFindUsers(t *testing.T) {
 			Response: &SearchResponse{
 				Users: []User{
 					{
-						ID:     34,
-						Name:   "Kane Sharp",
-						Age:    34,
-						About:  "Lorem proident sint minim anim commodo cillum. Eiusmod velit culpa commodo anim consectetur consectetur sint sint labore. Mollit consequat consectetur magna nulla veniam commodo eu ut et. Ut adipisicing qui ex consectetur officia sint ut fugiat ex velit cupidatat fugiat nisi non. Dolor minim mollit aliquip veniam nostrud. Magna eu aliqua Lorem aliquip.\n",
-						Gender: "male",
+						ID:            34,
+						Name:          "Kane Sharp",
+						Age:           34,
+						About:         "Lorem proident sint minim anim commodo cillum. Eiusmod velit culpa commodo anim consectetur consectetur sint sint labore. Mollit consequat consectetur magna nulla veniam commodo eu ut et. Ut adipisicing qui ex consectetur officia sint ut fugiat ex velit cupidatat fugiat nisi non. Dolor minim mollit aliquip veniam nostrud. Magna eu aliqua Lorem aliquip.\n",
+						Gender:        "male",
+						GUID:          "077040a1-8c2a-4571-b3f9-89d10e6603f3",
+						IsActive:      true,
+						Balance:       Money(161623),
+						Picture:       "http://placehold.it/32x32",
+						EyeColor:      "green",
+						Company:       "BUZZOPIA",
+						Email:         "kanesharp@buzzopia.com",
+						Phone:         "+1 (811) 473-3973",
+						Address:       "858 Alabama Avenue, Mulberry, Tennessee, 3103",
+						Registered:    time.Date(2014, 8, 9, 2, 4, 24, 0, time.FixedZone("", -3*60*60)),
+						FavoriteFruit: "strawberry",
 					},
 					{
-						ID:     33,
-						Name:   "Twila Snow",
-						Age:    36,
-						About:  "Sint non sunt adipisicing sit laborum cillum magna nisi exercitation. Dolore officia esse dolore officia ea adipisicing amet ea nostrud elit cupidatat laboris. Proident culpa ullamco aute incididunt aute. Laboris et nulla incididunt consequat pariatur enim dolor incididunt adipisicing enim fugiat tempor ullamco. Amet est ullamco officia consectetur cupidatat non sunt laborum nisi in ex. Quis labore quis ipsum est nisi ex officia reprehenderit ad adipisicing fugiat. Labore fugiat ea dolore exercitation sint duis aliqua.\n",
-						Gender: "female",
+						ID:            33,
+						Name:          "Twila Snow",
+						Age:           36,
+						About:         "Sint non sunt adipisicing sit laborum cillum magna nisi exercitation. Dolore officia esse dolore officia ea adipisicing amet ea nostrud elit cupidatat laboris. Proident culpa ullamco aute incididunt aute. Laboris et nulla incididunt consequat pariatur enim dolor incididunt adipisicing enim fugiat tempor ullamco. Amet est ullamco officia consectetur cupidatat non sunt laborum nisi in ex. Quis labore quis ipsum est nisi ex officia reprehenderit ad adipisicing fugiat. Labore fugiat ea dolore exercitation sint duis aliqua.\n",
+						Gender:        "female",
+						GUID:          "15affcba-ff5a-4b01-8795-1c6f636038de",
+						IsActive:      false,
+						Balance:       Money(323908),
+						Picture:       "http://placehold.it/32x32",
+						EyeColor:      "brown",
+						Company:       "LUNCHPAD",
+						Email:         "twilasnow@lunchpad.com",
+						Phone:         "+1 (995) 538-2962",
+						Address:       "646 Hoyt Street, Tilleda, Michigan, 9656",
+						Registered:    time.Date(2014, 8, 16, 8, 31, 28, 0, time.FixedZone("", -3*60*60)),
+						FavoriteFruit: "apple",
 					},
 				},
 				NextPage: true,
+				Total:    35,
 			},
 			IsError: false,
 		},
@@ -324,21 +579,44 @@ func TestFindUsers(t *testing.T) {
 			Response: &SearchResponse{
 				Users: []User{
 					{
-						ID:     13,
-						Name:   "Whitley Davidson",
-						Age:    40,
-						About:  "Consectetur dolore anim veniam aliqua deserunt officia eu. Et ullamco commodo ad officia duis ex incididunt proident consequat nostrud proident quis tempor. Sunt magna ad excepteur eu sint aliqua eiusmod deserunt proident. Do labore est dolore voluptate ullamco est dolore excepteur magna duis quis. Quis laborum deserunt ipsum velit occaecat est laborum enim aute. Officia dolore sit voluptate quis mollit veniam. Laborum nisi ullamco nisi sit nulla cillum et id nisi.\n",
-						Gender: "male",
+						ID:            13,
+						Name:          "Whitley Davidson",
+						Age:           40,
+						About:         "Consectetur dolore anim veniam aliqua deserunt officia eu. Et ullamco commodo ad officia duis ex incididunt proident consequat nostrud proident quis tempor. Sunt magna ad excepteur eu sint aliqua eiusmod deserunt proident. Do labore est dolore voluptate ullamco est dolore excepteur magna duis quis. Quis laborum deserunt ipsum velit occaecat est laborum enim aute. Officia dolore sit voluptate quis mollit veniam. Laborum nisi ullamco nisi sit nulla cillum et id nisi.\n",
+						Gender:        "male",
+						GUID:          "5b3cc38a-e78d-4e51-8deb-a943be9f02ab",
+						IsActive:      true,
+						Balance:       Money(111905),
+						Picture:       "http://placehold.it/32x32",
+						EyeColor:      "blue",
+						Company:       "COMTEXT",
+						Email:         "whitleydavidson@comtext.com",
+						Phone:         "+1 (839) 565-2229",
+						Address:       "198 Chestnut Street, Cliffside, Palau, 4383",
+						Registered:    time.Date(2014, 6, 22, 6, 4, 41, 0, time.FixedZone("", -3*60*60)),
+						FavoriteFruit: "banana",
 					},
 					{
-						ID:     33,
-						Name:   "Twila Snow",
-						Age:    36,
-						About:  "Sint non sunt adipisicing sit laborum cillum magna nisi exercitation. Dolore officia esse dolore officia ea adipisicing amet ea nostrud elit cupidatat laboris. Proident culpa ullamco aute incididunt aute. Laboris et nulla incididunt consequat pariatur enim dolor incididunt adipisicing enim fugiat tempor ullamco. Amet est ullamco officia consectetur cupidatat non sunt laborum nisi in ex. Quis labore quis ipsum est nisi ex officia reprehenderit ad adipisicing fugiat. Labore fugiat ea dolore exercitation sint duis aliqua.\n",
-						Gender: "female",
+						ID:            33,
+						Name:          "Twila Snow",
+						Age:           36,
+						About:         "Sint non sunt adipisicing sit laborum cillum magna nisi exercitation. Dolore officia esse dolore officia ea adipisicing amet ea nostrud elit cupidatat laboris. Proident culpa ullamco aute incididunt aute. Laboris et nulla incididunt consequat pariatur enim dolor incididunt adipisicing enim fugiat tempor ullamco. Amet est ullamco officia consectetur cupidatat non sunt laborum nisi in ex. Quis labore quis ipsum est nisi ex officia reprehenderit ad adipisicing fugiat. Labore fugiat ea dolore exercitation sint duis aliqua.\n",
+						Gender:        "female",
+						GUID:          "15affcba-ff5a-4b01-8795-1c6f636038de",
+						IsActive:      false,
+						Balance:       Money(323908),
+						Picture:       "http://placehold.it/32x32",
+						EyeColor:      "brown",
+						Company:       "LUNCHPAD",
+						Email:         "twilasnow@lunchpad.com",
+						Phone:         "+1 (995) 538-2962",
+						Address:       "646 Hoyt Street, Tilleda, Michigan, 9656",
+						Registered:    time.Date(2014, 8, 16, 8, 31, 28, 0, time.FixedZone("", -3*60*60)),
+						FavoriteFruit: "apple",
 					},
 				},
 				NextPage: true,
+				Total:    35,
 			},
 			IsError: false,
 		},
@@ -354,21 +632,44 @@ func TestFindUsers(t *testing.T) {
 			Response: &SearchResponse{
 				Users: []User{
 					{
-						ID:     13,
-						Name:   "Whitley Davidson",
-						Age:    40,
-						About:  "Consectetur dolore anim veniam aliqua deserunt officia eu. Et ullamco commodo ad officia duis ex incididunt proident consequat nostrud proident quis tempor. Sunt magna ad excepteur eu sint aliqua eiusmod deserunt proident. Do labore est dolore voluptate ullamco est dolore excepteur magna duis quis. Quis laborum deserunt ipsum velit occaecat est laborum enim aute. Officia dolore sit voluptate quis mollit veniam. Laborum nisi ullamco nisi sit nulla cillum et id nisi.\n",
-						Gender: "male",
+						ID:            13,
+						Name:          "Whitley Davidson",
+						Age:           40,
+						About:         "Consectetur dolore anim veniam aliqua deserunt officia eu. Et ullamco commodo ad officia duis ex incididunt proident consequat nostrud proident quis tempor. Sunt magna ad excepteur eu sint aliqua eiusmod deserunt proident. Do labore est dolore voluptate ullamco est dolore excepteur magna duis quis. Quis laborum deserunt ipsum velit occaecat est laborum enim aute. Officia dolore sit voluptate quis mollit veniam. Laborum nisi ullamco nisi sit nulla cillum et id nisi.\n",
+						Gender:        "male",
+						GUID:          "5b3cc38a-e78d-4e51-8deb-a943be9f02ab",
+						IsActive:      true,
+						Balance:       Money(111905),
+						Picture:       "http://placehold.it/32x32",
+						EyeColor:      "blue",
+						Company:       "COMTEXT",
+						Email:         "whitleydavidson@comtext.com",
+						Phone:         "+1 (839) 565-2229",
+						Address:       "198 Chestnut Street, Cliffside, Palau, 4383",
+						Registered:    time.Date(2014, 6, 22, 6, 4, 41, 0, time.FixedZone("", -3*60*60)),
+						FavoriteFruit: "banana",
 					},
 					{
-						ID:     32,
-						Name:   "Christy Knapp",
-						Age:    40,
-						About:  "Incididunt culpa dolore laborum cupidatat consequat. Aliquip cupidatat pariatur sit consectetur laboris labore anim labore. Est sint ut ipsum dolor ipsum nisi tempor in tempor aliqua. Aliquip labore cillum est consequat anim officia non reprehenderit ex duis elit. Amet aliqua eu ad velit incididunt ad ut magna. Culpa dolore qui anim consequat commodo aute.\n",
-						Gender: "female",
+						ID:            32,
+						Name:          "Christy Knapp",
+						Age:           40,
+						About:         "Incididunt culpa dolore laborum cupidatat consequat. Aliquip cupidatat pariatur sit consectetur laboris labore anim labore. Est sint ut ipsum dolor ipsum nisi tempor in tempor aliqua. Aliquip labore cillum est consequat anim officia non reprehenderit ex duis elit. Amet aliqua eu ad velit incididunt ad ut magna. Culpa dolore qui anim consequat commodo aute.\n",
+						Gender:        "female",
+						GUID:          "49c236e7-89e5-4e9f-94cd-9f7aabf914dc",
+						IsActive:      true,
+						Balance:       Money(365951),
+						Picture:       "http://placehold.it/32x32",
+						EyeColor:      "blue",
+						Company:       "XANIDE",
+						Email:         "christyknapp@xanide.com",
+						Phone:         "+1 (825) 499-3933",
+						Address:       "914 Porter Avenue, Kirk, Massachusetts, 9088",
+						Registered:    time.Date(2016, 12, 21, 5, 48, 37, 0, time.FixedZone("", -3*60*60)),
+						FavoriteFruit: "strawberry",
 					},
 				},
 				NextPage: true,
+				Total:    35,
 			},
 			IsError: false,
 		},
@@ -384,21 +685,248 @@ func TestFindUsers(t *testing.T) {
 			Response: &SearchResponse{
 				Users: []User{
 					{
-						ID:     13,
-						Name:   "Whitley Davidson",
-						Age:    40,
-						About:  "Consectetur dolore anim veniam aliqua deserunt officia eu. Et ullamco commodo ad officia duis ex incididunt proident consequat nostrud proident quis tempor. Sunt magna ad excepteur eu sint aliqua eiusmod deserunt proident. Do labore est dolore voluptate ullamco est dolore excepteur magna duis quis. Quis laborum deserunt ipsum velit occaecat est laborum enim aute. Officia dolore sit voluptate quis mollit veniam. Laborum nisi ullamco nisi sit nulla cillum et id nisi.\n",
-						Gender: "male",
+						ID:            13,
+						Name:          "Whitley Davidson",
+						Age:           40,
+						About:         "Consectetur dolore anim veniam aliqua deserunt officia eu. Et ullamco commodo ad officia duis ex incididunt proident consequat nostrud proident quis tempor. Sunt magna ad excepteur eu sint aliqua eiusmod deserunt proident. Do labore est dolore voluptate ullamco est dolore excepteur magna duis quis. Quis laborum deserunt ipsum velit occaecat est laborum enim aute. Officia dolore sit voluptate quis mollit veniam. Laborum nisi ullamco nisi sit nulla cillum et id nisi.\n",
+						Gender:        "male",
+						GUID:          "5b3cc38a-e78d-4e51-8deb-a943be9f02ab",
+						IsActive:      true,
+						Balance:       Money(111905),
+						Picture:       "http://placehold.it/32x32",
+						EyeColor:      "blue",
+						Company:       "COMTEXT",
+						Email:         "whitleydavidson@comtext.com",
+						Phone:         "+1 (839) 565-2229",
+						Address:       "198 Chestnut Street, Cliffside, Palau, 4383",
+						Registered:    time.Date(2014, 6, 22, 6, 4, 41, 0, time.FixedZone("", -3*60*60)),
+						FavoriteFruit: "banana",
 					},
 					{
-						ID:     33,
-						Name:   "Twila Snow",
-						Age:    36,
-						About:  "Sint non sunt adipisicing sit laborum cillum magna nisi exercitation. Dolore officia esse dolore officia ea adipisicing amet ea nostrud elit cupidatat laboris. Proident culpa ullamco aute incididunt aute. Laboris et nulla incididunt consequat pariatur enim dolor incididunt adipisicing enim fugiat tempor ullamco. Amet est ullamco officia consectetur cupidatat non sunt laborum nisi in ex. Quis labore quis ipsum est nisi ex officia reprehenderit ad adipisicing fugiat. Labore fugiat ea dolore exercitation sint duis aliqua.\n",
-						Gender: "female",
+						ID:            33,
+						Name:          "Twila Snow",
+						Age:           36,
+						About:         "Sint non sunt adipisicing sit laborum cillum magna nisi exercitation. Dolore officia esse dolore officia ea adipisicing amet ea nostrud elit cupidatat laboris. Proident culpa ullamco aute incididunt aute. Laboris et nulla incididunt consequat pariatur enim dolor incididunt adipisicing enim fugiat tempor ullamco. Amet est ullamco officia consectetur cupidatat non sunt laborum nisi in ex. Quis labore quis ipsum est nisi ex officia reprehenderit ad adipisicing fugiat. Labore fugiat ea dolore exercitation sint duis aliqua.\n",
+						Gender:        "female",
+						GUID:          "15affcba-ff5a-4b01-8795-1c6f636038de",
+						IsActive:      false,
+						Balance:       Money(323908),
+						Picture:       "http://placehold.it/32x32",
+						EyeColor:      "brown",
+						Company:       "LUNCHPAD",
+						Email:         "twilasnow@lunchpad.com",
+						Phone:         "+1 (995) 538-2962",
+						Address:       "646 Hoyt Street, Tilleda, Michigan, 9656",
+						Registered:    time.Date(2014, 8, 16, 8, 31, 28, 0, time.FixedZone("", -3*60*60)),
+						FavoriteFruit: "apple",
+					},
+				},
+				NextPage: true,
+				Total:    35,
+			},
+			IsError: false,
+		},
+		"test-19: with fields projection": {
+			DatasetName: FileDataset,
+			AccessToken: "token",
+			Request: SearchRequest{
+				Limit:      2,
+				Offset:     0,
+				Query:      "cillum",
+				OrderField: OrderFieldID,
+				OrderBy:    OrderByAsc,
+				Fields:     []string{"id", "name", "age"},
+			},
+			Response: &SearchResponse{
+				Users: []User{
+					{ID: 0, Name: "Boyd Wolf", Age: 22},
+					{ID: 2, Name: "Brooks Aguilar", Age: 25},
+				},
+				NextPage: true,
+				Total:    18,
+			},
+			IsError: false,
+		},
+		"test-20: with wrong fields": {
+			DatasetName: FileDataset,
+			AccessToken: "token",
+			Request: SearchRequest{
+				Limit:  2,
+				Offset: 0,
+				Fields: []string{"id", "password"},
+			},
+			Response: nil,
+			IsError:  true,
+		},
+		"test-21: with wrong query": {
+			DatasetName: FileDataset,
+			AccessToken: "token",
+			Request: SearchRequest{
+				Limit:  2,
+				Offset: 0,
+				Query:  "(name:boyd",
+			},
+			Response: nil,
+			IsError:  true,
+		},
+		"test-22: with case insensitive match": {
+			DatasetName: FileDataset,
+			AccessToken: "token",
+			Request: SearchRequest{
+				Limit:      2,
+				Offset:     0,
+				Query:      "Adipisicing",
+				OrderField: OrderFieldID,
+				OrderBy:    OrderByAsc,
+				Fields:     []string{"id", "name"},
+				MatchMode:  MatchCI,
+			},
+			Response: &SearchResponse{
+				Users: []User{
+					{ID: 2, Name: "Brooks Aguilar"},
+					{ID: 3, Name: "Everett Dillard"},
+				},
+				NextPage: true,
+				Total:    20,
+			},
+			IsError: false,
+		},
+		"test-23: with wrong match mode": {
+			DatasetName: FileDataset,
+			AccessToken: "token",
+			Request: SearchRequest{
+				Limit:     2,
+				Offset:    0,
+				MatchMode: "soundex",
+			},
+			Response: nil,
+			IsError:  true,
+		},
+		"test-24: with highlight": {
+			DatasetName: FileDataset,
+			AccessToken: "token",
+			Request: SearchRequest{
+				Limit:     2,
+				Offset:    0,
+				Query:     "Boyd",
+				Fields:    []string{"id"},
+				Highlight: true,
+			},
+			Response: &SearchResponse{
+				Users: []User{
+					{ID: 0},
+				},
+				NextPage: false,
+				Total:    1,
+				Highlights: map[int][]Highlight{
+					0: {
+						{
+							Field:    "Name",
+							Fragment: "Boyd Wolf",
+							Matches:  []HighlightMatch{{Start: 0, End: 4, RuneStart: 0, RuneEnd: 4}},
+						},
 					},
 				},
+			},
+			IsError: false,
+		},
+		"test-25: with multi-key sort": {
+			DatasetName: FileDataset,
+			AccessToken: "token",
+			Request: SearchRequest{
+				Limit:  3,
+				Offset: 0,
+				Fields: []string{"id", "age"},
+				Sort: []SortKey{
+					{Field: OrderFieldAge, OrderBy: OrderByDesc},
+					{Field: OrderFieldID, OrderBy: OrderByDesc},
+				},
+			},
+			Response: &SearchResponse{
+				Users: []User{
+					{ID: 32, Age: 40},
+					{ID: 13, Age: 40},
+					{ID: 26, Age: 39},
+				},
 				NextPage: true,
+				Total:    35,
+			},
+			IsError: false,
+		},
+		"test-26: with wrong sort field": {
+			DatasetName: FileDataset,
+			AccessToken: "token",
+			Request: SearchRequest{
+				Limit: 2,
+				Sort:  []SortKey{{Field: "password", OrderBy: OrderByAsc}},
+			},
+			Response: nil,
+			IsError:  true,
+		},
+		"test-27: with order_field balance": {
+			DatasetName: FileDataset,
+			AccessToken: "token",
+			Request: SearchRequest{
+				Limit:      2,
+				Offset:     0,
+				OrderField: "balance",
+				OrderBy:    OrderByDesc,
+				Fields:     []string{"id", "balance"},
+			},
+			Response: &SearchResponse{
+				Users: []User{
+					{ID: 4, Balance: Money(397065)},
+					{ID: 11, Balance: Money(392547)},
+				},
+				NextPage: true,
+				Total:    35,
+			},
+			IsError: false,
+		},
+		"test-28: with sort by gender and registered": {
+			DatasetName: FileDataset,
+			AccessToken: "token",
+			Request: SearchRequest{
+				Limit:  2,
+				Offset: 0,
+				Fields: []string{"id"},
+				Sort: []SortKey{
+					{Field: "gender", OrderBy: OrderByDesc},
+					{Field: "registered", OrderBy: OrderByAsc},
+				},
+			},
+			Response: &SearchResponse{
+				Users: []User{
+					{ID: 18},
+					{ID: 4},
+				},
+				NextPage: true,
+				Total:    35,
+			},
+			IsError: false,
+		},
+		"test-29: with wrong collation": {
+			DatasetName: FileDataset,
+			AccessToken: "token",
+			Request: SearchRequest{
+				Limit:     2,
+				Offset:    0,
+				Collation: "locale",
+			},
+			Response: nil,
+			IsError:  true,
+		},
+		"test-30: with offset past the end": {
+			DatasetName: FileDataset,
+			AccessToken: "token",
+			Request: SearchRequest{
+				Limit:  5,
+				Offset: 1000,
+			},
+			Response: &SearchResponse{
+				Users:    []User{},
+				NextPage: false,
+				Total:    35,
 			},
 			IsError: false,
 		},
@@ -426,7 +954,6 @@ func TestFindUsers(t *testing.T) {
 
 	for name, item := range cases {
 		server := httptest.NewServer(http.HandlerFunc(SearchServer))
-		defer server.Close()
 
 		url := item.URL
 		if len(url) == 0 {
@@ -441,14 +968,897 @@ func TestFindUsers(t *testing.T) {
 		FileDataset = item.DatasetName
 
 		response, err := client.FindUsers(item.Request)
+		// Close ждет и оборванные по таймауту обработчики, чтобы они не читали FileDataset следующего случая
+		server.Close()
 		if err != nil && !item.IsError {
 			t.Errorf("[%s] unexpected error: %v", name, err)
 		}
 		if err == nil && item.IsError {
 			t.Errorf("[%s] expected error, got nil", name)
 		}
+		if response != nil {
+			if response.NextPage != (len(response.NextCursor) > 0) {
+				t.Errorf("[%s] NextCursor %q does not match NextPage %v", name, response.NextCursor, response.NextPage)
+			}
+			response.NextCursor = ""
+		}
 		if !reflect.DeepEqual(item.Response, response) {
 			t.Errorf("[%s] wrong result, expected %#v, got %#v", name, item.Response, response)
 		}
 	}
 }
+
+func TestSearchQuerySlow(t *testing.T) {
+	cases := map[string]bool{
+		"qwertyuiopasdfghjklzxcvbnm":       true,
+		"cillum":                           false,
+		"name:Boyd OR about:Boyd":          false,
+		"about:qwertyuiopasdfghjklz":       false,
+		"qwertyuiopasdfghjklzxcvbn*":       false,
+		`"nisi mollit est Lorem pariatur"`: false,
+		`"qwertyuiopasdfghjklzxcvbnm"`:     false,
+	}
+
+	for raw, slow := range cases {
+		root, err := parseQuery(raw, matcher{})
+		if err != nil {
+			t.Fatalf("[%s] unexpected error: %v", raw, err)
+		}
+		if got := (searchQuery{raw: raw, root: root}).slow(); got != slow {
+			t.Errorf("[%s] wrong slow: got %v want %v", raw, got, slow)
+		}
+	}
+
+	FileDataset = "dataset.xml"
+	server := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer server.Close()
+	client := &SearchClient{AccessToken: "token", URL: server.URL}
+
+	for _, query := range []string{"name:Boyd OR about:Boyd", `"nisi mollit est Lorem pariatur"`} {
+		resp, err := client.FindUsers(SearchRequest{Limit: 5, Query: query})
+		if err != nil {
+			t.Fatalf("[%s] long query must not time out: %v", query, err)
+		}
+		if len(resp.Users) == 0 {
+			t.Errorf("[%s] expected users", query)
+		}
+	}
+}
+
+func TestFindUsersQueryError(t *testing.T) {
+	FileDataset = "dataset.xml"
+
+	server := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer server.Close()
+
+	client := &SearchClient{AccessToken: "token", URL: server.URL}
+	_, err := client.FindUsers(SearchRequest{Limit: 1, Query: "age:>old"})
+
+	var queryErr *QueryError
+	if !errors.As(err, &queryErr) {
+		t.Fatalf("expected *QueryError, got %#v", err)
+	}
+	if queryErr.Query != "age:>old" {
+		t.Errorf("wrong query in error: got %q want %q", queryErr.Query, "age:>old")
+	}
+}
+
+func TestFindUsersCursor(t *testing.T) {
+	FileDataset = "dataset.xml"
+
+	server := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer server.Close()
+	client := &SearchClient{AccessToken: "token", URL: server.URL}
+
+	cases := map[string]struct {
+		Request SearchRequest
+		Count   int
+		Total   int
+	}{
+		"sorted":  {Request: SearchRequest{Limit: 4, Sort: []SortKey{{Field: OrderFieldAge, OrderBy: OrderByDesc}}}, Count: 35, Total: 35},
+		"as is":   {Request: SearchRequest{Limit: 3, Query: "cillum"}, Count: 18, Total: 18},
+		"offset":  {Request: SearchRequest{Limit: 10, Offset: 30, OrderField: OrderFieldName, OrderBy: OrderByAsc}, Count: 5, Total: 35},
+		"one row": {Request: SearchRequest{Limit: 25, Query: "name:Boyd"}, Count: 1, Total: 1},
+	}
+
+	for name, item := range cases {
+		users := []User{}
+		err := client.EachPage(item.Request, func(page *SearchResponse) error {
+			users = append(users, page.Users...)
+			if page.Total != item.Total {
+				t.Errorf("[%s] wrong total: got %d want %d", name, page.Total, item.Total)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("[%s] unexpected error: %v", name, err)
+		}
+		if len(users) != item.Count {
+			t.Errorf("[%s] wrong users count: got %d want %d", name, len(users), item.Count)
+		}
+
+		seen := make(map[int]bool)
+		for i, user := range users {
+			if seen[user.ID] {
+				t.Errorf("[%s] user %d returned twice", name, user.ID)
+			}
+			seen[user.ID] = true
+			if name == "sorted" && i > 0 && (user.Age > users[i-1].Age || user.Age == users[i-1].Age && user.ID < users[i-1].ID) {
+				t.Errorf("[%s] wrong order: %d after %d", name, user.ID, users[i-1].ID)
+			}
+		}
+	}
+
+	first, err := client.FindUsers(SearchRequest{Limit: 2, Query: "cillum", OrderField: OrderFieldAge, OrderBy: OrderByAsc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, req := range map[string]SearchRequest{
+		"other query":    {Limit: 2, Query: "nisi", OrderField: OrderFieldAge, OrderBy: OrderByAsc, Cursor: first.NextCursor},
+		"other order":    {Limit: 2, Query: "cillum", OrderField: OrderFieldAge, OrderBy: OrderByDesc, Cursor: first.NextCursor},
+		"tampered":       {Limit: 2, Query: "cillum", OrderField: OrderFieldAge, OrderBy: OrderByAsc, Cursor: "x" + first.NextCursor},
+		"not signed":     {Limit: 2, Query: "cillum", OrderField: OrderFieldAge, OrderBy: OrderByAsc, Cursor: "e30"},
+		"wrong encoding": {Limit: 2, Query: "cillum", OrderField: OrderFieldAge, OrderBy: OrderByAsc, Cursor: "!.!"},
+	} {
+		if _, err := client.FindUsers(req); err == nil {
+			t.Errorf("[%s] expected error, got nil", name)
+		}
+	}
+}
+
+func TestFindUsersZeroLimit(t *testing.T) {
+	FileDataset = "dataset.xml"
+
+	server := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer server.Close()
+	client := &SearchClient{AccessToken: "token", URL: server.URL}
+
+	cases := map[string]struct {
+		Request  SearchRequest
+		NextPage bool
+	}{
+		"rows left":     {Request: SearchRequest{Limit: 0}, NextPage: true},
+		"at the end":    {Request: SearchRequest{Limit: 0, Offset: 35}, NextPage: false},
+		"nothing found": {Request: SearchRequest{Limit: 0, Query: "qwerty"}, NextPage: false},
+	}
+
+	for name, item := range cases {
+		resp, err := client.FindUsers(item.Request)
+		if err != nil {
+			t.Fatalf("[%s] unexpected error: %v", name, err)
+		}
+		if len(resp.Users) != 0 || resp.NextPage != item.NextPage || len(resp.NextCursor) > 0 {
+			t.Errorf("[%s] wrong page: %d users, NextPage %v, cursor %q", name, len(resp.Users), resp.NextPage, resp.NextCursor)
+		}
+	}
+}
+
+func TestFindUsersCursorAfterInsert(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dataset.xml")
+	writeDataset := func(ids ...int) {
+		data := "<root>"
+		for _, id := range ids {
+			data += "<row><id>" + strconv.Itoa(id) + "</id><age>" + strconv.Itoa(20+id) + "</age></row>"
+		}
+		if err := os.WriteFile(path, []byte(data+"</root>"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeDataset(1, 2, 3, 4, 5)
+
+	FileDataset = path
+	defer func() { FileDataset = "dataset.xml" }()
+
+	server := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer server.Close()
+	client := &SearchClient{AccessToken: "token", URL: server.URL}
+
+	req := SearchRequest{Limit: 2, OrderField: OrderFieldAge, OrderBy: OrderByAsc}
+	first, err := client.FindUsers(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// новая строка попадает на уже отданную страницу и не должна сдвигать следующую
+	writeDataset(0, 1, 2, 3, 4, 5)
+	if err = datasets.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	req.Cursor = first.NextCursor
+	second, err := client.FindUsers(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []int{}
+	for _, user := range second.Users {
+		ids = append(ids, user.ID)
+	}
+	if !reflect.DeepEqual([]int{3, 4}, ids) {
+		t.Errorf("wrong page after insert: got %v want %v", ids, []int{3, 4})
+	}
+}
+
+func TestFindUsersAPIError(t *testing.T) {
+	cases := map[string]struct {
+		Status int
+		Code   string
+		Target error
+	}{
+		"limit":      {Status: http.StatusBadRequest, Code: ErrorBadLimit, Target: ErrBadLimit},
+		"offset":     {Status: http.StatusBadRequest, Code: ErrorBadOffset, Target: ErrBadOffset},
+		"order_by":   {Status: http.StatusBadRequest, Code: ErrorBadOrderBy, Target: ErrBadOrderBy},
+		"order":      {Status: http.StatusBadRequest, Code: ErrorBadOrderField, Target: ErrBadOrderField},
+		"query":      {Status: http.StatusBadRequest, Code: ErrorBadQuery, Target: ErrBadQuery},
+		"cursor":     {Status: http.StatusBadRequest, Code: ErrorBadCursor, Target: ErrBadCursor},
+		"highlight":  {Status: http.StatusBadRequest, Code: ErrorBadHighlight, Target: ErrBadHighlight},
+		"unknown":    {Status: http.StatusBadRequest, Code: "password invalid", Target: ErrBadRequest},
+		"token":      {Status: http.StatusUnauthorized, Target: ErrBadAccessToken},
+		"fatal":      {Status: http.StatusInternalServerError, Target: ErrServerFatal},
+		"forbidden":  {Status: http.StatusForbidden, Code: "nope", Target: ErrUnexpectedStatus},
+		"not found":  {Status: http.StatusNotFound, Target: ErrUnexpectedStatus},
+		"gateway":    {Status: http.StatusGatewayTimeout, Code: "nope", Target: ErrUnexpectedStatus},
+		"empty code": {Status: http.StatusBadRequest, Code: "", Target: ErrBadRequest},
+	}
+
+	for name, item := range cases {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if item.Status == http.StatusBadRequest {
+				badRequest(w, item.Code)
+				return
+			}
+			w.WriteHeader(item.Status)
+			if len(item.Code) > 0 {
+				json.NewEncoder(w).Encode(SearchErrorResponse{Error: item.Code}) //nolint:errcheck
+			}
+		}))
+
+		client := &SearchClient{AccessToken: "token", URL: server.URL}
+		_, err := client.FindUsers(SearchRequest{Limit: 3, Query: "cillum"})
+		server.Close()
+
+		if !errors.Is(err, item.Target) {
+			t.Errorf("[%s] expected %v, got %v", name, item.Target, err)
+		}
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) {
+			t.Errorf("[%s] expected *APIError, got %#v", name, err)
+			continue
+		}
+		if apiErr.StatusCode != item.Status || apiErr.Code != item.Code {
+			t.Errorf("[%s] wrong status or code: %d %q", name, apiErr.StatusCode, apiErr.Code)
+		}
+		if apiErr.Params.Get("limit") != "3" || apiErr.Params.Get("query") != "cillum" {
+			t.Errorf("[%s] wrong params: %v", name, apiErr.Params)
+		}
+	}
+}
+
+func TestFindUsersClientErrors(t *testing.T) {
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("offset") == "1" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		w.Write([]byte("not json")) //nolint:errcheck
+	}))
+	defer broken.Close()
+	closed := httptest.NewServer(http.HandlerFunc(SearchServer))
+	closed.Close()
+
+	cases := map[string]struct {
+		URL     string
+		Request SearchRequest
+		Target  error
+	}{
+		"negative limit":   {URL: broken.URL, Request: SearchRequest{Limit: -1}, Target: ErrBadLimit},
+		"negative offset":  {URL: broken.URL, Request: SearchRequest{Offset: -1}, Target: ErrBadOffset},
+		"bad sort order":   {URL: broken.URL, Request: SearchRequest{Sort: []SortKey{{Field: OrderFieldAge, OrderBy: 5}}}, Target: ErrBadOrderBy},
+		"bad result json":  {URL: broken.URL, Request: SearchRequest{Limit: 1}, Target: ErrBadResponse},
+		"bad error json":   {URL: broken.URL, Request: SearchRequest{Limit: 1, Offset: 1}, Target: ErrBadResponse},
+		"server not found": {URL: closed.URL, Request: SearchRequest{Limit: 1}, Target: ErrRequestFailed},
+	}
+
+	for name, item := range cases {
+		client := &SearchClient{AccessToken: "token", URL: item.URL}
+		_, err := client.FindUsers(item.Request)
+		if !errors.Is(err, item.Target) {
+			t.Errorf("[%s] expected %v, got %v", name, item.Target, err)
+		}
+	}
+}
+
+// countingTransport считает запросы, прошедшие через клиента
+type countingTransport struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	c.calls++
+	c.mu.Unlock()
+
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestFindUsersContext(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	deadline, cancelDeadline := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelDeadline()
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cases := map[string]struct {
+		Client  *SearchClient
+		Ctx     context.Context
+		Target  error
+		Timeout bool
+	}{
+		"client timeout": {
+			Client:  &SearchClient{AccessToken: "token", URL: slow.URL, Timeout: 50 * time.Millisecond},
+			Ctx:     context.Background(),
+			Target:  context.DeadlineExceeded,
+			Timeout: true,
+		},
+		"http client timeout": {
+			Client:  &SearchClient{AccessToken: "token", URL: slow.URL, HTTPClient: &http.Client{Timeout: 50 * time.Millisecond}},
+			Ctx:     context.Background(),
+			Target:  context.DeadlineExceeded,
+			Timeout: true,
+		},
+		"context deadline": {
+			Client:  &SearchClient{AccessToken: "token", URL: slow.URL},
+			Ctx:     deadline,
+			Target:  context.DeadlineExceeded,
+			Timeout: true,
+		},
+		"context canceled": {
+			Client: &SearchClient{AccessToken: "token", URL: slow.URL},
+			Ctx:    canceled,
+			Target: context.Canceled,
+		},
+	}
+
+	for name, item := range cases {
+		_, err := item.Client.FindUsersContext(item.Ctx, SearchRequest{Limit: 1})
+		if !errors.Is(err, item.Target) {
+			t.Errorf("[%s] expected %v, got %v", name, item.Target, err)
+			continue
+		}
+		if item.Timeout && !strings.HasPrefix(err.Error(), "timeout for ") {
+			t.Errorf("[%s] wrong timeout message: %v", name, err)
+		}
+	}
+
+	FileDataset = "dataset.xml"
+	server := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer server.Close()
+
+	transport := &countingTransport{}
+	client := &SearchClient{AccessToken: "token", URL: server.URL, HTTPClient: &http.Client{Transport: transport}}
+	if _, err := client.FindUsersContext(context.Background(), SearchRequest{Limit: 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if transport.calls != 1 {
+		t.Errorf("custom HTTPClient not used: %d calls", transport.calls)
+	}
+}
+
+func TestFindUsersLongTimeout(t *testing.T) {
+	FileDataset = "dataset.xml"
+
+	// отвечает дольше секунды - таймаута клиента по умолчанию
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(1100 * time.Millisecond):
+		}
+		SearchServer(w, r)
+	}))
+	defer slow.Close()
+
+	if _, err := (&SearchClient{AccessToken: "token", URL: slow.URL}).FindUsers(SearchRequest{Limit: 1}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("default client must time out after a second, got %v", err)
+	}
+	client := &SearchClient{AccessToken: "token", URL: slow.URL, Timeout: 3 * time.Second}
+	if _, err := client.FindUsers(SearchRequest{Limit: 1}); err != nil {
+		t.Errorf("Timeout longer than the default must be honored: %v", err)
+	}
+}
+
+func TestUserScanner(t *testing.T) {
+	FileDataset = "dataset.xml"
+
+	server := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer server.Close()
+	client := &SearchClient{AccessToken: "token", URL: server.URL}
+
+	scanner := client.Scan(context.Background(), SearchRequest{Limit: 4, OrderField: OrderFieldID, OrderBy: OrderByAsc})
+	ids := []int{}
+	for scanner.Next() {
+		ids = append(ids, scanner.User().ID)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ids) != 35 || ids[0] != 0 || ids[34] != 34 {
+		t.Errorf("wrong users: %v", ids)
+	}
+	if scanner.Next() {
+		t.Error("finished scanner must not return more users")
+	}
+
+	count := 0
+	for scanner = client.Scan(context.Background(), SearchRequest{}); scanner.Next(); {
+		count++
+	}
+	if scanner.Err() != nil || count != 35 {
+		t.Errorf("zero Limit must scan with MaxLimit pages: got %d users, err %v", count, scanner.Err())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	scanner = client.Scan(ctx, SearchRequest{Limit: 4})
+	count = 0
+	for scanner.Next() {
+		count++
+		if count == 6 {
+			cancel()
+		}
+	}
+	if !errors.Is(scanner.Err(), context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", scanner.Err())
+	}
+	if count != 6 {
+		t.Errorf("scanner must stop right after cancel: got %d users", count)
+	}
+
+	scanner = (&SearchClient{AccessToken: "bad", URL: server.URL}).Scan(context.Background(), SearchRequest{Limit: 4})
+	if scanner.Next() {
+		t.Error("expected no users for bad token")
+	}
+	if scanner.Err() == nil {
+		t.Error("expected error for bad token, got nil")
+	}
+}
+
+func TestSearchServerResponseVersion(t *testing.T) {
+	FileDataset = "dataset.xml"
+
+	cases := map[string]struct {
+		Version  string
+		Status   int
+		Envelope bool
+	}{
+		"default":   {Version: "", Status: http.StatusOK},
+		"version 1": {Version: "1", Status: http.StatusOK},
+		"version 2": {Version: "2", Status: http.StatusOK, Envelope: true},
+		"unknown":   {Version: "3", Status: http.StatusBadRequest},
+	}
+
+	for name, item := range cases {
+		params := url.Values{}
+		params.Add("limit", "3")
+		params.Add("offset", "1")
+		params.Add("order_by", "0")
+		params.Add("query", "cillum")
+		params.Add("version", item.Version)
+
+		req, err := http.NewRequest("GET", "/?"+params.Encode(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("AccessToken", "token")
+
+		rr := httptest.NewRecorder()
+		SearchServer(rr, req)
+
+		if status := rr.Code; status != item.Status {
+			t.Errorf("[%s] wrong status code: got %v want %v", name, status, item.Status)
+			continue
+		}
+		if item.Status != http.StatusOK {
+			continue
+		}
+
+		if !item.Envelope {
+			users := []User{}
+			if err := json.Unmarshal(rr.Body.Bytes(), &users); err != nil {
+				t.Errorf("[%s] expected bare array: %v", name, err)
+			}
+			continue
+		}
+
+		page := searchPage{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+			t.Fatalf("[%s] expected envelope: %v", name, err)
+		}
+		if page.Total != 18 || page.Limit != 3 || page.Offset != 1 || len(page.Users) != 3 {
+			t.Errorf("[%s] wrong envelope: total %d, limit %d, offset %d, users %d", name, page.Total, page.Limit, page.Offset, len(page.Users))
+		}
+	}
+}
+
+func TestSearchServerETag(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dataset.xml")
+	writeDataset := func(data string, modTime time.Time) {
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	writeDataset(`<root><row><id>1</id><age>30</age></row><row><id>2</id><age>20</age></row></root>`, modTime)
+
+	FileDataset = path
+	defer func() { FileDataset = "dataset.xml" }()
+
+	search := func(query, ifNoneMatch string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("AccessToken", "token")
+		if len(ifNoneMatch) > 0 {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rr := httptest.NewRecorder()
+		SearchServer(rr, req)
+		return rr
+	}
+
+	first := search("limit=1&offset=0&order_by=1&order_field=age", "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || len(etag) == 0 {
+		t.Fatalf("expected 200 with ETag, got %d %q", first.Code, etag)
+	}
+	if lastModified := first.Header().Get("Last-Modified"); lastModified != modTime.Format(http.TimeFormat) {
+		t.Errorf("wrong Last-Modified: %q", lastModified)
+	}
+
+	cases := map[string]struct {
+		Query       string
+		IfNoneMatch string
+		Status      int
+		SameETag    bool
+	}{
+		"same request":        {Query: "limit=1&offset=0&order_by=1&order_field=age", IfNoneMatch: etag, Status: http.StatusNotModified, SameETag: true},
+		"params reordered":    {Query: "order_field=age&order_by=1&offset=0&limit=1", IfNoneMatch: etag, Status: http.StatusNotModified, SameETag: true},
+		"weak and listed":     {Query: "limit=1&offset=0&order_by=1&order_field=age", IfNoneMatch: `"other", W/` + etag, Status: http.StatusNotModified, SameETag: true},
+		"any":                 {Query: "limit=1&offset=0&order_by=1&order_field=age", IfNoneMatch: "*", Status: http.StatusNotModified, SameETag: true},
+		"other etag":          {Query: "limit=1&offset=0&order_by=1&order_field=age", IfNoneMatch: `"other"`, Status: http.StatusOK, SameETag: true},
+		"other params":        {Query: "limit=1&offset=1&order_by=1&order_field=age", IfNoneMatch: etag, Status: http.StatusOK},
+		"bad params, no etag": {Query: "limit=1&offset=-1&order_by=1", IfNoneMatch: etag, Status: http.StatusBadRequest},
+	}
+
+	for name, item := range cases {
+		rr := search(item.Query, item.IfNoneMatch)
+		if rr.Code != item.Status {
+			t.Errorf("[%s] wrong status code: got %v want %v", name, rr.Code, item.Status)
+		}
+		got := rr.Header().Get("ETag")
+		if item.Status == http.StatusBadRequest {
+			if len(got) > 0 {
+				t.Errorf("[%s] error response must not carry ETag", name)
+			}
+			continue
+		}
+		if (got == etag) != item.SameETag {
+			t.Errorf("[%s] wrong ETag %q, first was %q", name, got, etag)
+		}
+		if item.Status == http.StatusNotModified && rr.Body.Len() > 0 {
+			t.Errorf("[%s] 304 must not have a body", name)
+		}
+	}
+
+	writeDataset(`<root><row><id>1</id><age>30</age></row><row><id>2</id><age>20</age></row><row><id>3</id></row></root>`, modTime.Add(time.Hour))
+	if err := datasets.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	changed := search("limit=1&offset=0&order_by=1&order_field=age", etag)
+	if changed.Code != http.StatusOK || changed.Header().Get("ETag") == etag {
+		t.Errorf("dataset change must invalidate ETag: got %d %q", changed.Code, changed.Header().Get("ETag"))
+	}
+}
+
+func TestSearchServerRelevance(t *testing.T) {
+	FileDataset = "dataset.xml"
+
+	params := url.Values{}
+	params.Add("limit", "25")
+	params.Add("offset", "0")
+	params.Add("query", "cillum")
+	params.Add("order_field", OrderFieldRelevance)
+	params.Add("order_by", strconv.Itoa(OrderByDesc))
+
+	req, err := http.NewRequest("GET", "/?"+params.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("AccessToken", "token")
+
+	rr := httptest.NewRecorder()
+	SearchServer(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	users := Users{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &users); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) == 0 {
+		t.Fatal("expected users, got none")
+	}
+	for i, user := range users {
+		if user.Score <= 0 {
+			t.Errorf("user %d has no score", user.ID)
+		}
+		if i > 0 && user.Score > users[i-1].Score {
+			t.Errorf("users are not ordered by score: %v after %v", user.Score, users[i-1].Score)
+		}
+	}
+}
+
+// snapshotUsers возвращает копию пользователей из снимка датасета path
+func snapshotUsers(store *datasetStore, path string) (Users, error) {
+	snapshot, err := store.Snapshot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.Clone(snapshot.users), nil
+}
+
+func TestDatasetStore(t *testing.T) {
+	store := &datasetStore{}
+
+	if _, err := snapshotUsers(store, "data.xml"); err == nil {
+		t.Fatal("expected error for missing dataset, got nil")
+	}
+	if store.snapshot.Load() != nil {
+		t.Fatal("failed load must not be cached")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			users, err := snapshotUsers(store, "dataset.xml")
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			sortUsers(users, []sortKey{{field: OrderFieldID, orderBy: OrderByDesc}}, nil)
+		}()
+	}
+	wg.Wait()
+
+	users, err := snapshotUsers(store, "dataset.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 35 {
+		t.Fatalf("wrong users count: got %d want %d", len(users), 35)
+	}
+	if users[0].ID != 0 {
+		t.Errorf("cached dataset was modified by caller: first ID %d", users[0].ID)
+	}
+}
+
+func TestDatasetStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dataset.xml")
+	writeDataset := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeDataset(`<root><row><id>1</id><first_name>Boyd</first_name></row></root>`)
+
+	store := &datasetStore{}
+	if _, err := snapshotUsers(store, path); err != nil {
+		t.Fatal(err)
+	}
+
+	writeDataset(`<root><row><id>1</id></row><row><id>2</id><first_name>Hilda</first_name></row></root>`)
+	if err := store.Reload(); err != nil {
+		t.Fatalf("unexpected reload error: %v", err)
+	}
+	users, _ := snapshotUsers(store, path) //nolint:errcheck
+	if len(users) != 2 {
+		t.Fatalf("reload not applied: got %d users want %d", len(users), 2)
+	}
+
+	writeDataset(`<root><row><id>broken</id></row></root>`)
+	if err := store.Reload(); err == nil {
+		t.Fatal("expected reload error, got nil")
+	}
+	if store.ReloadError() == nil {
+		t.Error("reload error is not reported")
+	}
+	users, _ = snapshotUsers(store, path) //nolint:errcheck
+	if len(users) != 2 {
+		t.Errorf("last good snapshot must be kept: got %d users want %d", len(users), 2)
+	}
+
+	for name, data := range map[string]string{
+		"empty":     "",
+		"garbage":   "hello world",
+		"truncated": `<root><row><id>1</id></row><row><id>2`,
+	} {
+		writeDataset(data)
+		if err := store.Reload(); err == nil {
+			t.Errorf("[%s] expected reload error, got nil", name)
+		}
+		users, _ = snapshotUsers(store, path) //nolint:errcheck
+		if len(users) != 2 {
+			t.Errorf("[%s] last good snapshot must be kept: got %d users want %d", name, len(users), 2)
+		}
+	}
+
+	// без явного Reload изменение подхватывает Snapshot при очередном обращении
+	store.checkInterval = 10 * time.Millisecond
+	writeDataset(`<root><row><id>3</id></row></root>`)
+	deadline := time.After(time.Second)
+	for {
+		users, _ = snapshotUsers(store, path) //nolint:errcheck
+		if len(users) == 1 && users[0].ID == 3 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("Snapshot did not pick up dataset change")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if err := store.ReloadError(); err != nil {
+		t.Errorf("reload error must be cleared after successful reload: %v", err)
+	}
+}
+
+func TestSearchServerDatasetChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dataset.xml")
+	writeDataset := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeDataset(`<root><row><id>1</id></row></root>`)
+
+	FileDataset = path
+	datasets.checkInterval = time.Nanosecond
+	defer func() {
+		FileDataset = "dataset.xml"
+		datasets.checkInterval = 0
+	}()
+
+	server := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer server.Close()
+	client := &SearchClient{AccessToken: "token", URL: server.URL}
+
+	steps := []struct {
+		Name  string
+		Data  string
+		Total int
+	}{
+		{Name: "initial", Total: 1},
+		{Name: "rows added", Data: `<root><row><id>1</id></row><row><id>2</id></row><row><id>3</id></row></root>`, Total: 3},
+		{Name: "broken file keeps last good", Data: `<root><row><id>broken</id></row></root>`, Total: 3},
+		{Name: "fixed", Data: `<root><row><id>4</id></row><row><id>5</id></row></root>`, Total: 2},
+	}
+
+	for _, step := range steps {
+		if len(step.Data) > 0 {
+			writeDataset(step.Data)
+		}
+		resp, err := client.FindUsers(SearchRequest{Limit: 10})
+		if err != nil {
+			t.Fatalf("[%s] unexpected error: %v", step.Name, err)
+		}
+		if resp.Total != step.Total {
+			t.Errorf("[%s] wrong total: got %d want %d", step.Name, resp.Total, step.Total)
+		}
+	}
+}
+
+func TestScanUsers(t *testing.T) {
+	cases := map[string]struct {
+		Data    string
+		IDs     []int
+		IsError bool
+	}{
+		"all rows": {
+			Data: `<root><row><id>1</id><about>foo</about></row><row><id>2</id><about>bar</about></row></root>`,
+			IDs:  []int{1, 2},
+		},
+		"no rows": {
+			Data: `<root></root>`,
+			IDs:  []int{},
+		},
+		"empty": {
+			Data:    "",
+			IsError: true,
+		},
+		"not xml": {
+			Data:    "hello world",
+			IsError: true,
+		},
+		"broken row": {
+			Data:    `<root><row><id>1</id></row><row><id>x</id></row></root>`,
+			IsError: true,
+		},
+		"unclosed root": {
+			Data:    `<root><row><id>1</id></row>`,
+			IsError: true,
+		},
+	}
+
+	for name, item := range cases {
+		users := Users{}
+		err := scanUsers(strings.NewReader(item.Data), func(user User) error {
+			users = append(users, user)
+			return nil
+		})
+		if err != nil && !item.IsError {
+			t.Errorf("[%s] unexpected error: %v", name, err)
+		}
+		if err == nil && item.IsError {
+			t.Errorf("[%s] expected error, got nil", name)
+		}
+
+		ids := []int{}
+		for _, user := range users {
+			ids = append(ids, user.ID)
+		}
+		if !item.IsError && !reflect.DeepEqual(item.IDs, ids) {
+			t.Errorf("[%s] wrong result, expected %v, got %v", name, item.IDs, ids)
+		}
+	}
+}
+
+func TestMoney(t *testing.T) {
+	cases := map[string]struct {
+		Text    string
+		Money   Money
+		String  string
+		IsError bool
+	}{
+		"with thousands":  {Text: "$2,144.93", Money: 214493, String: "$2,144.93"},
+		"without dollar":  {Text: "1200", Money: 120000, String: "$1,200.00"},
+		"negative":        {Text: "-$3.5", Money: -350, String: "-$3.50"},
+		"millions":        {Text: "$1,234,567.01", Money: 123456701, String: "$1,234,567.01"},
+		"too many digits": {Text: "$1.234", IsError: true},
+		"not a number":    {Text: "$abc", IsError: true},
+	}
+
+	for name, item := range cases {
+		var money Money
+		err := money.UnmarshalText([]byte(item.Text))
+		if err != nil && !item.IsError {
+			t.Errorf("[%s] unexpected error: %v", name, err)
+		}
+		if err == nil && item.IsError {
+			t.Errorf("[%s] expected error, got nil", name)
+		}
+		if item.IsError {
+			continue
+		}
+		if money != item.Money {
+			t.Errorf("[%s] wrong value, expected %d, got %d", name, item.Money, money)
+		}
+		if money.String() != item.String {
+			t.Errorf("[%s] wrong string, expected %s, got %s", name, item.String, money.String())
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"cmp"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// HeaderNextCursor - заголовок ответа с курсором следующей страницы, нет заголовка - нет страницы
+const HeaderNextCursor = "X-Next-Cursor"
+
+// cursorSecret подписывает курсоры; курсоры, выданные до перезапуска сервера, становятся недействительны
+var cursorSecret = newCursorSecret()
+
+func newCursorSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+
+	return secret
+}
+
+// searchCursor - позиция последней отданной строки: значения ее ключей сортировки и ID.
+// Scope привязывает курсор к сортировке и запросу, с которыми он выдан.
+type searchCursor struct {
+	Scope  string            `json:"s"`
+	Values []json.RawMessage `json:"v,omitempty"`
+	ID     int               `json:"id"`
+
+	values []interface{}
+}
+
+// cursorScope описывает выдачу, внутри которой курсор имеет смысл
+func cursorScope(r *http.Request, keys []sortKey) string {
+	order := make([]string, 0, len(keys))
+	for _, key := range keys {
+		order = append(order, fmt.Sprintf("%s:%d", key.field, key.orderBy))
+	}
+	params := r.URL.Query()
+
+	return strings.Join([]string{
+		strings.Join(order, ","),
+		params.Get("collation"),
+		params.Get("match"),
+		params.Get("query"),
+	}, "\n")
+}
+
+// cursorValue - значение ключа сортировки, которое сохраняется в курсоре
+func cursorValue(field string, u User) interface{} {
+	if f := userFields[field]; f.value != nil {
+		return f.value(u)
+	}
+
+	return u.Score
+}
+
+// encodeCursor выдает подписанный курсор, указывающий на user
+func encodeCursor(user User, params searchParams) (string, error) {
+	cursor := searchCursor{Scope: params.scope, ID: user.ID}
+	for _, key := range params.sortKeys {
+		value, err := json.Marshal(cursorValue(key.field, user))
+		if err != nil {
+			return "", err
+		}
+		cursor.Values = append(cursor.Values, value)
+	}
+
+	payload, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(signCursor(payload)), nil
+}
+
+func signCursor(payload []byte) []byte {
+	mac := hmac.New(sha256.New, cursorSecret)
+	mac.Write(payload) //nolint:errcheck
+
+	return mac.Sum(nil)
+}
+
+// decodeCursor проверяет подпись курсора и то, что он выдан для той же сортировки и запроса
+func decodeCursor(token string, params searchParams) (*searchCursor, error) {
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found {
+		return nil, fmt.Errorf(ErrorBadCursor)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf(ErrorBadCursor)
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, signCursor(payload)) {
+		return nil, fmt.Errorf(ErrorBadCursor)
+	}
+
+	cursor := &searchCursor{}
+	if err = json.Unmarshal(payload, cursor); err != nil {
+		return nil, fmt.Errorf(ErrorBadCursor)
+	}
+	if cursor.Scope != params.scope || len(cursor.Values) != len(params.sortKeys) {
+		return nil, fmt.Errorf(ErrorBadCursor)
+	}
+
+	for i, key := range params.sortKeys {
+		value, err := decodeCursorValue(cursor.Values[i], cursorValue(key.field, User{}))
+		if err != nil {
+			return nil, fmt.Errorf(ErrorBadCursor)
+		}
+		cursor.values = append(cursor.values, value)
+	}
+
+	return cursor, nil
+}
+
+// decodeCursorValue разбирает значение в тип, который имеет zero
+func decodeCursorValue(raw json.RawMessage, zero interface{}) (interface{}, error) {
+	switch zero.(type) {
+	case int:
+		return unmarshalValue[int](raw)
+	case Money:
+		return unmarshalValue[Money](raw)
+	case bool:
+		return unmarshalValue[bool](raw)
+	case time.Time:
+		return unmarshalValue[time.Time](raw)
+	case string:
+		return unmarshalValue[string](raw)
+	case float64:
+		return unmarshalValue[float64](raw)
+	}
+
+	return nil, fmt.Errorf("unknown cursor value type %T", zero)
+}
+
+func unmarshalValue[T any](raw json.RawMessage) (interface{}, error) {
+	var value T
+	err := json.Unmarshal(raw, &value)
+	return value, err
+}
+
+// after оставляет пользователей, которые в выдаче идут строго после курсора.
+// Без сортировки позиция ищется по ID; если строки курсора больше нет, продолжить нельзя.
+func (c *searchCursor) after(users Users, keys []sortKey, collate collator) (Users, error) {
+	if len(keys) == 0 {
+		i := slices.IndexFunc(users, func(u User) bool { return u.ID == c.ID })
+		if i < 0 {
+			return nil, fmt.Errorf(ErrorBadCursor)
+		}
+		return users[i+1:], nil
+	}
+
+	result := Users{}
+	for _, user := range users {
+		if c.compare(user, keys, collate) > 0 {
+			result = append(result, user)
+		}
+	}
+
+	return result, nil
+}
+
+// compare сравнивает пользователя с позицией курсора в порядке выдачи, равные ключи упорядочены по ID
+func (c *searchCursor) compare(user User, keys []sortKey, collate collator) int {
+	for i, key := range keys {
+		a, b := cursorValue(key.field, user), c.values[i]
+		var result int
+		if s, ok := a.(string); ok && collate != nil {
+			result = collate(s, b.(string))
+		} else {
+			result = compareValues(a, b)
+		}
+		if result == 0 {
+			continue
+		}
+		if key.orderBy == OrderByDesc {
+			return -result
+		}
+		return result
+	}
+
+	return cmp.Compare(user.ID, c.ID)
+}
+
+func parseCursorParam(r *http.Request, params searchParams) (*searchCursor, error) {
+	token := r.URL.Query().Get("cursor")
+	if len(token) == 0 {
+		return nil, nil
+	}
+
+	return decodeCursor(token, params)
+}
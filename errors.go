@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Ошибки FindUsers, проверяются через errors.Is. Ответы сервера с ошибкой приходят как *APIError,
+// который разворачивается в одну из них. Неверные Limit, Offset и Sort FindUsers отклоняет сам
+// с ErrBadLimit, ErrBadOffset и ErrBadOrderBy, не обращаясь к серверу.
+var (
+	ErrBadAccessToken = errors.New("bad AccessToken")
+	ErrServerFatal    = errors.New("SearchServer fatal error")
+	// сервер временно недоступен (502, 503)
+	ErrServerUnavailable = errors.New("SearchServer unavailable")
+	// сервер просит снизить частоту запросов (429)
+	ErrTooManyRequests = errors.New("too many requests")
+	// сервер вернул 400 с неизвестным кодом ошибки
+	ErrBadRequest = errors.New("bad request")
+	// запрос не дошел до сервера или ответ не удалось прочитать
+	ErrRequestFailed = errors.New("request failed")
+	// ответ сервера не разбирается
+	ErrBadResponse = errors.New("bad SearchServer response")
+	// сервер ответил статусом, которого клиент не ждет (403, 404, 504 и т.п.)
+	ErrUnexpectedStatus = errors.New("unexpected SearchServer status")
+
+	ErrBadLimit      = errors.New(ErrorBadLimit)
+	ErrBadOffset     = errors.New(ErrorBadOffset)
+	ErrBadOrderField = errors.New(ErrorBadOrderField)
+	ErrBadOrderBy    = errors.New(ErrorBadOrderBy)
+	ErrBadFields     = errors.New(ErrorBadFields)
+	ErrBadQuery      = errors.New(ErrorBadQuery)
+	ErrBadMatch      = errors.New(ErrorBadMatch)
+	ErrBadHighlight  = errors.New(ErrorBadHighlight)
+	ErrBadCollation  = errors.New(ErrorBadCollation)
+	ErrBadCursor     = errors.New(ErrorBadCursor)
+	ErrBadVersion    = errors.New(ErrorBadVersion)
+)
+
+// badRequestErrors сопоставляет коды SearchErrorResponse.Error ошибкам клиента
+var badRequestErrors = map[string]error{
+	ErrorBadLimit:      ErrBadLimit,
+	ErrorBadOffset:     ErrBadOffset,
+	ErrorBadOrderField: ErrBadOrderField,
+	ErrorBadOrderBy:    ErrBadOrderBy,
+	ErrorBadFields:     ErrBadFields,
+	ErrorBadQuery:      ErrBadQuery,
+	ErrorBadMatch:      ErrBadMatch,
+	ErrorBadHighlight:  ErrBadHighlight,
+	ErrorBadCollation:  ErrBadCollation,
+	ErrorBadCursor:     ErrBadCursor,
+	ErrorBadVersion:    ErrBadVersion,
+}
+
+// APIError - SearchServer ответил ошибкой
+type APIError struct {
+	StatusCode int
+	// SearchErrorResponse.Error, для ответов без тела - пусто
+	Code string
+	// параметры запроса, на который пришла ошибка
+	Params url.Values
+
+	err     error
+	message string
+}
+
+func (e *APIError) Error() string {
+	return e.message
+}
+
+// Unwrap дает одну из ошибок Err* или *QueryError
+func (e *APIError) Unwrap() error {
+	return e.err
+}
+
+func (e *QueryError) Unwrap() error {
+	return ErrBadQuery
+}
+
+// unexpectedStatusError описывает ответ с незнакомым статусом; код ошибки берется из тела, если оно есть
+func unexpectedStatusError(status int, body []byte, params url.Values) *APIError {
+	errResp := SearchErrorResponse{}
+	json.Unmarshal(body, &errResp) //nolint:errcheck
+
+	return &APIError{
+		StatusCode: status,
+		Code:       errResp.Error,
+		Params:     params,
+		err:        ErrUnexpectedStatus,
+		message:    fmt.Sprintf("%s: %d", ErrUnexpectedStatus, status),
+	}
+}
+
+// badRequestError описывает ответ 400 с кодом code понятным для вызывающего сообщением
+func badRequestError(code string, req SearchRequest, order []string, params url.Values) *APIError {
+	apiErr := &APIError{StatusCode: http.StatusBadRequest, Code: code, Params: params, err: badRequestErrors[code]}
+
+	switch code {
+	case ErrorBadLimit:
+		apiErr.message = fmt.Sprintf("Limit %d invalid", req.Limit)
+	case ErrorBadOffset:
+		apiErr.message = fmt.Sprintf("Offset %d invalid", req.Offset)
+	case ErrorBadOrderField:
+		if len(order) > 0 {
+			apiErr.message = fmt.Sprintf("Sort %s invalid", strings.Join(order, ","))
+		} else {
+			apiErr.message = fmt.Sprintf("OrderFeld %s invalid", req.OrderField)
+		}
+	case ErrorBadOrderBy:
+		if len(order) > 0 {
+			apiErr.message = fmt.Sprintf("Sort %s invalid", strings.Join(order, ","))
+		} else {
+			apiErr.message = fmt.Sprintf("OrderBy %d invalid", req.OrderBy)
+		}
+	case ErrorBadQuery:
+		apiErr.err = &QueryError{Query: req.Query}
+		apiErr.message = apiErr.err.Error()
+	case ErrorBadMatch:
+		apiErr.message = fmt.Sprintf("MatchMode %s invalid", req.MatchMode)
+	case ErrorBadCollation:
+		apiErr.message = fmt.Sprintf("Collation %s invalid", req.Collation)
+	case ErrorBadCursor:
+		apiErr.message = "Cursor invalid"
+	case ErrorBadFields:
+		apiErr.message = fmt.Sprintf("Fields %s invalid", strings.Join(req.Fields, ","))
+	case ErrorBadHighlight, ErrorBadVersion:
+		apiErr.message = apiErr.err.Error()
+	default:
+		apiErr.err = ErrBadRequest
+		apiErr.message = fmt.Sprintf("unknown bad request error: %s", code)
+	}
+
+	return apiErr
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// searchETag - версия ответа: снимок датасета и параметры запроса в нормализованном виде.
+// Секрет курсоров тоже входит в версию: после перезапуска сервера старые курсоры недействительны,
+// и закэшированные с ними страницы не должны подтверждаться через 304.
+func searchETag(snapshot *datasetSnapshot, r *http.Request) string {
+	h := sha256.New()
+	h.Write(cursorSecret) //nolint:errcheck
+	fmt.Fprintf(h, "\n%s\n%d\n%d\n%s", snapshot.path, snapshot.modTime.UnixNano(), snapshot.size, r.URL.Query().Encode())
+
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// etagMatches проверяет If-None-Match: список версий через запятую или *, слабые версии W/ тоже подходят
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setVersionHeaders сообщает клиенту версию ответа и время изменения датасета
+func setVersionHeaders(w http.ResponseWriter, snapshot *datasetSnapshot, etag string) {
+	w.Header().Set("ETag", etag)
+	if !snapshot.modTime.IsZero() {
+		w.Header().Set("Last-Modified", snapshot.modTime.UTC().Format(http.TimeFormat))
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// userField - поле пользователя. Одна запись в userFields делает поле доступным
+// в параметре fields (если задан key), в query (если задан value) и в сортировке (если задан compare).
+type userField struct {
+	key     string // ключ в json ответе, совпадает с именем поля в User
+	value   func(u User) interface{}
+	compare func(a, b User) int
+	// для строковых полей: при сортировке значения сравниваются через collator из запроса
+	text func(u User) string
+	// в запросе значение поля сравнивается целиком, а не ищется как подстрока
+	keyword bool
+}
+
+var userFields = map[string]userField{
+	"id":             intField("ID", func(u User) int { return u.ID }),
+	"name":           stringField("Name", func(u User) string { return u.Name }),
+	"age":            intField("Age", func(u User) int { return u.Age }),
+	"about":          stringField("About", func(u User) string { return u.About }),
+	"gender":         keywordField("Gender", func(u User) string { return u.Gender }),
+	"guid":           keywordField("GUID", func(u User) string { return u.GUID }),
+	"is_active":      boolField("IsActive", func(u User) bool { return u.IsActive }),
+	"balance":        moneyField("Balance", func(u User) Money { return u.Balance }),
+	"picture":        stringField("Picture", func(u User) string { return u.Picture }),
+	"eye_color":      keywordField("EyeColor", func(u User) string { return u.EyeColor }),
+	"company":        stringField("Company", func(u User) string { return u.Company }),
+	"email":          stringField("Email", func(u User) string { return u.Email }),
+	"phone":          stringField("Phone", func(u User) string { return u.Phone }),
+	"address":        stringField("Address", func(u User) string { return u.Address }),
+	"registered":     timeField("Registered", func(u User) time.Time { return u.Registered }),
+	"favorite_fruit": keywordField("FavoriteFruit", func(u User) string { return u.FavoriteFruit }),
+
+	// вычисляемые поля: в ответ не попадают
+	"about_length": intField("", func(u User) int { return utf8.RuneCountInString(u.About) }),
+	"relevance":    {compare: func(a, b User) int { return cmp.Compare(a.Score, b.Score) }},
+}
+
+func stringField(key string, get func(User) string) userField {
+	return userField{
+		key:     key,
+		value:   func(u User) interface{} { return get(u) },
+		compare: func(a, b User) int { return strings.Compare(get(a), get(b)) },
+		text:    get,
+	}
+}
+
+func keywordField(key string, get func(User) string) userField {
+	f := stringField(key, get)
+	f.keyword = true
+	return f
+}
+
+func intField(key string, get func(User) int) userField {
+	return userField{
+		key:     key,
+		value:   func(u User) interface{} { return get(u) },
+		compare: func(a, b User) int { return cmp.Compare(get(a), get(b)) },
+	}
+}
+
+func boolField(key string, get func(User) bool) userField {
+	return userField{
+		key:     key,
+		value:   func(u User) interface{} { return get(u) },
+		compare: func(a, b User) int { return cmp.Compare(boolToInt(get(a)), boolToInt(get(b))) },
+	}
+}
+
+func moneyField(key string, get func(User) Money) userField {
+	return userField{
+		key:     key,
+		value:   func(u User) interface{} { return get(u) },
+		compare: func(a, b User) int { return cmp.Compare(get(a), get(b)) },
+	}
+}
+
+func timeField(key string, get func(User) time.Time) userField {
+	return userField{
+		key:     key,
+		value:   func(u User) interface{} { return get(u) },
+		compare: func(a, b User) int { return get(a).Compare(get(b)) },
+	}
+}
+
+// parseFieldsParam разбирает список полей вида "id,name,age". Пустой список - все поля.
+func parseFieldsParam(r *http.Request) ([]string, error) {
+	param := r.URL.Query().Get("fields")
+	if len(param) == 0 {
+		return nil, nil
+	}
+
+	seen := make(map[string]bool)
+	fields := []string{}
+	for _, field := range strings.Split(param, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		if f, ok := userFields[field]; !ok || len(f.key) == 0 {
+			return nil, fmt.Errorf(ErrorBadFields)
+		}
+		if seen[field] {
+			continue
+		}
+		seen[field] = true
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// projectUsers оставляет у пользователей только запрошенные поля.
+// highlights, если не nil, выровнен по users и добавляется ключом Highlights.
+func projectUsers(users Users, fields []string, highlights [][]Highlight) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(users))
+	for i, user := range users {
+		item := make(map[string]interface{}, len(fields))
+		for _, field := range fields {
+			f := userFields[field]
+			item[f.key] = f.value(user)
+		}
+		if user.Score != 0 {
+			item["Score"] = user.Score
+		}
+		if highlights != nil && len(highlights[i]) > 0 {
+			item["Highlights"] = highlights[i]
+		}
+		result = append(result, item)
+	}
+
+	return result
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// snippetRadius - сколько байт контекста оставлять вокруг совпадения во фрагменте About
+const snippetRadius = 40
+
+// highlightUsers ищет термы запроса в Name и About, терм с полем - только в своем поле; результат выровнен по users
+func highlightUsers(users Users, query searchQuery) [][]Highlight {
+	terms := positiveTerms(query.root)
+	result := make([][]Highlight, len(users))
+	if len(terms) == 0 {
+		return result
+	}
+
+	for i, user := range users {
+		if spans := findSpans("Name", user.Name, terms); len(spans) > 0 {
+			result[i] = append(result[i], newHighlight("Name", user.Name, 0, len(user.Name), spans))
+		}
+		for _, window := range snippetWindows(user.About, findSpans("About", user.About, terms)) {
+			result[i] = append(result[i], newHighlight("About", user.About, window.start, window.end, window.spans))
+		}
+	}
+
+	return result
+}
+
+// textSpan - совпадение [start, end) в байтах исходной строки
+type textSpan struct {
+	start, end int
+}
+
+// findSpans находит в поле field все вхождения его термов с учетом режима match и сливает пересекающиеся
+func findSpans(field, text string, terms []queryNode) []textSpan {
+	spans := []textSpan{}
+	for _, term := range terms {
+		if f := termField(term); len(f) > 0 && f != field {
+			continue
+		}
+		var m matcher
+		var needle string
+		prefix := false
+		switch n := term.(type) {
+		case textNode:
+			m, needle = n.matcher, n.text
+		case prefixNode:
+			m, needle, prefix = n.matcher, n.prefix, true
+		}
+		if len(needle) == 0 {
+			continue
+		}
+
+		normalized, offsets := m.normalizeOffsets(text)
+		for from := 0; from < len(normalized); {
+			i := strings.Index(normalized[from:], needle)
+			if i < 0 {
+				break
+			}
+			start, end := from+i, from+i+len(needle)
+			from = end
+			if prefix && start > 0 {
+				r, _ := utf8.DecodeLastRuneInString(normalized[:start])
+				if isTermRune(r) {
+					continue
+				}
+			}
+			spans = append(spans, textSpan{start: offsets[start], end: offsets[end]})
+		}
+	}
+
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].start < spans[j].start
+	})
+	merged := []textSpan{}
+	for _, span := range spans {
+		if n := len(merged); n > 0 && span.start <= merged[n-1].end {
+			merged[n-1].end = max(merged[n-1].end, span.end)
+			continue
+		}
+		merged = append(merged, span)
+	}
+
+	return merged
+}
+
+// normalizeOffsets нормализует s и для каждого байта результата запоминает смещение в s.
+// Последний элемент offsets - len(s), чтобы конец совпадения тоже можно было перевести.
+func (m matcher) normalizeOffsets(s string) (string, []int) {
+	if m.fold == nil {
+		offsets := make([]int, len(s)+1)
+		for i := range offsets {
+			offsets[i] = i
+		}
+		return s, offsets
+	}
+
+	var b strings.Builder
+	offsets := make([]int, 0, len(s)+1)
+	for i, r := range s {
+		folded := m.fold(r)
+		if folded < 0 {
+			continue
+		}
+		n, _ := b.WriteRune(folded) //nolint:errcheck
+		for j := 0; j < n; j++ {
+			offsets = append(offsets, i)
+		}
+	}
+	offsets = append(offsets, len(s))
+
+	return b.String(), offsets
+}
+
+// snippetWindow - кусок About вокруг одного или нескольких близких совпадений
+type snippetWindow struct {
+	start, end int
+	spans      []textSpan
+}
+
+func snippetWindows(text string, spans []textSpan) []snippetWindow {
+	windows := []snippetWindow{}
+	for _, span := range spans {
+		start := runeStart(text, max(span.start-snippetRadius, 0))
+		end := runeStart(text, min(span.end+snippetRadius, len(text)))
+		if n := len(windows); n > 0 && start <= windows[n-1].end {
+			windows[n-1].end = max(windows[n-1].end, end)
+			windows[n-1].spans = append(windows[n-1].spans, span)
+			continue
+		}
+		windows = append(windows, snippetWindow{start: start, end: end, spans: []textSpan{span}})
+	}
+
+	return windows
+}
+
+// runeStart сдвигает смещение i назад к началу руны
+func runeStart(text string, i int) int {
+	for i > 0 && i < len(text) && !utf8.RuneStart(text[i]) {
+		i--
+	}
+
+	return i
+}
+
+func newHighlight(field, text string, start, end int, spans []textSpan) Highlight {
+	fragment := text[start:end]
+	h := Highlight{Field: field, Fragment: fragment, Matches: make([]HighlightMatch, 0, len(spans))}
+	for _, span := range spans {
+		s, e := span.start-start, span.end-start
+		h.Matches = append(h.Matches, HighlightMatch{
+			Start:     s,
+			End:       e,
+			RuneStart: utf8.RuneCountInString(fragment[:s]),
+			RuneEnd:   utf8.RuneCountInString(fragment[:e]),
+		})
+	}
+
+	return h
+}
+
+func parseHighlightParam(r *http.Request) (bool, error) {
+	param := r.URL.Query().Get("highlight")
+	if len(param) == 0 {
+		return false, nil
+	}
+
+	highlight, err := strconv.ParseBool(param)
+	if err != nil {
+		return false, fmt.Errorf(ErrorBadHighlight)
+	}
+
+	return highlight, nil
+}
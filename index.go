@@ -0,0 +1,181 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// userIndex - инвертированный индекс по словам из Name и About.
+// Слова хранятся в нижнем регистре и без диакритики, поэтому индекс годится для любого режима match:
+// он лишь отбирает кандидатов, а окончательную проверку делает queryNode.match.
+type userIndex struct {
+	postings map[string][]int // слово -> отсортированные ID пользователей
+	terms    []string         // отсортированный словарь для поиска по префиксу и подстроке
+
+	// статистика для ранжирования: число пользователей и средняя длина текста в словах с учетом веса Name
+	docs      int
+	avgLength float64
+}
+
+func newUserIndex(users Users) *userIndex {
+	idx := &userIndex{postings: make(map[string][]int), docs: len(users)}
+	totalLength := 0
+	for _, user := range users {
+		totalLength += nameWeight*len(indexTerms(user.Name)) + len(indexTerms(user.About))
+		for _, term := range indexTerms(user.Name + " " + user.About) {
+			ids := idx.postings[term]
+			if len(ids) > 0 && ids[len(ids)-1] == user.ID {
+				continue
+			}
+			idx.postings[term] = append(ids, user.ID)
+		}
+	}
+
+	idx.terms = make([]string, 0, len(idx.postings))
+	for term, ids := range idx.postings {
+		slices.Sort(ids)
+		idx.postings[term] = slices.Compact(ids)
+		idx.terms = append(idx.terms, term)
+	}
+	sort.Strings(idx.terms)
+	if len(users) > 0 {
+		idx.avgLength = float64(totalLength) / float64(len(users))
+	}
+
+	return idx
+}
+
+// indexNormalize приводит текст к виду, в котором хранятся слова индекса
+func indexNormalize(s string) string {
+	return strings.Map(lowerFoldRune, s)
+}
+
+// lowerFoldRune убирает диакритику и приводит руну к нижнему регистру; -1 - руну нужно выбросить
+func lowerFoldRune(r rune) rune {
+	if r = foldRune(r); r < 0 {
+		return r
+	}
+
+	return unicode.ToLower(r)
+}
+
+func isTermRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func indexTerms(s string) []string {
+	return strings.FieldsFunc(indexNormalize(s), func(r rune) bool {
+		return !isTermRune(r)
+	})
+}
+
+// lookup отбирает ID пользователей, которые могут подойти под запрос.
+// ok=false - запрос индексом не обслуживается и нужен полный проход.
+func (idx *userIndex) lookup(node queryNode) (ids []int, ok bool) {
+	switch n := node.(type) {
+	case textNode:
+		if n.field != nil && n.field.key != "Name" && n.field.key != "About" {
+			return nil, false
+		}
+		return idx.substring(n.text)
+	case prefixNode:
+		if n.field != nil && n.field.key != "Name" && n.field.key != "About" {
+			return nil, false
+		}
+		return idx.prefix(n.prefix)
+	case andNode:
+		left, leftOK := idx.lookup(n.left)
+		right, rightOK := idx.lookup(n.right)
+		switch {
+		case leftOK && rightOK:
+			return intersectIDs(left, right), true
+		case leftOK:
+			return left, true
+		case rightOK:
+			return right, true
+		}
+	case orNode:
+		left, leftOK := idx.lookup(n.left)
+		right, rightOK := idx.lookup(n.right)
+		if leftOK && rightOK {
+			return unionIDs(left, right), true
+		}
+	}
+
+	return nil, false
+}
+
+// substring находит пользователей, у которых какое-то слово содержит text.
+// Текст с пробелами и знаками препинания задевает несколько слов, такие запросы индекс не обслуживает.
+func (idx *userIndex) substring(text string) ([]int, bool) {
+	term := indexNormalize(text)
+	if len(term) == 0 || strings.IndexFunc(term, func(r rune) bool { return !isTermRune(r) }) >= 0 {
+		return nil, false
+	}
+
+	var ids []int
+	for _, t := range idx.terms {
+		if strings.Contains(t, term) {
+			ids = unionIDs(ids, idx.postings[t])
+		}
+	}
+
+	return ids, true
+}
+
+// prefix находит пользователей, у которых какое-то слово начинается с prefix
+func (idx *userIndex) prefix(prefix string) ([]int, bool) {
+	term := indexNormalize(prefix)
+	if len(term) == 0 || strings.IndexFunc(term, func(r rune) bool { return !isTermRune(r) }) >= 0 {
+		return nil, false
+	}
+
+	var ids []int
+	for i := sort.SearchStrings(idx.terms, term); i < len(idx.terms) && strings.HasPrefix(idx.terms[i], term); i++ {
+		ids = unionIDs(ids, idx.postings[idx.terms[i]])
+	}
+
+	return ids, true
+}
+
+func intersectIDs(a, b []int) []int {
+	result := []int{}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+
+	return result
+}
+
+func unionIDs(a, b []int) []int {
+	result := make([]int, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			result = append(result, a[i])
+			i++
+		case a[i] > b[j]:
+			result = append(result, b[j])
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	result = append(result, a[i:]...)
+
+	return append(result, b[j:]...)
+}
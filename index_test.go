@@ -0,0 +1,127 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestUserIndexMatchesScan(t *testing.T) {
+	users, _, err := loadUsers("dataset.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := newUserIndex(users)
+
+	queries := []string{"cillum", "Adipisicing", "illu", "Boyd", "oyd", "qwerty", "nisi mollit", "sunt.", "cill*", "Whit*"}
+	for _, user := range users[:5] {
+		queries = append(queries, strings.Fields(user.About)...)
+	}
+
+	for _, mode := range []string{MatchExact, MatchCI, MatchFold} {
+		m, _ := newMatcher(mode) //nolint:errcheck
+		for _, raw := range queries {
+			root, err := parseQuery(raw, m)
+			if err != nil {
+				t.Fatalf("[%s %s] unexpected error: %v", mode, raw, err)
+			}
+
+			scan := queryUsers(users, searchQuery{raw: raw, root: root})
+			indexed := searchQuery{raw: raw, root: root}
+			indexed.useIndex(idx)
+			got := queryUsers(users, indexed)
+
+			if !reflect.DeepEqual(scan, got) {
+				t.Errorf("[%s %s] index result differs from scan: %d vs %d users", mode, raw, len(got), len(scan))
+			}
+		}
+	}
+}
+
+func TestUserIndexLookup(t *testing.T) {
+	idx := newUserIndex(Users{
+		{ID: 3, Name: "José Álvarez", About: "Lorem ipsum"},
+		{ID: 1, Name: "Boyd Wolf", About: "Lorem dolor, sit."},
+		{ID: 2, Name: "Hilda Mayer", About: "Ipsum dolore"},
+	})
+
+	cases := map[string]struct {
+		Query string
+		IDs   []int
+		OK    bool
+	}{
+		"term":            {Query: "lorem", IDs: []int{1, 3}, OK: true},
+		"substring":       {Query: "olo", IDs: []int{1, 2}, OK: true},
+		"prefix":          {Query: "dolor*", IDs: []int{1, 2}, OK: true},
+		"folded":          {Query: "alvarez", IDs: []int{3}, OK: true},
+		"and":             {Query: "lorem ipsum", IDs: []int{3}, OK: true},
+		"or":              {Query: "boyd OR hilda", IDs: []int{1, 2}, OK: true},
+		"and with filter": {Query: "lorem age:>10", IDs: []int{1, 3}, OK: true},
+		"not":             {Query: "NOT lorem", OK: false},
+		"phrase":          {Query: `"dolor, sit"`, OK: false},
+		"other field":     {Query: "email:boyd", OK: false},
+	}
+
+	for name, item := range cases {
+		root, err := parseQuery(item.Query, matcher{})
+		if err != nil {
+			t.Fatalf("[%s] unexpected error: %v", name, err)
+		}
+
+		ids, ok := idx.lookup(root)
+		if ok != item.OK {
+			t.Errorf("[%s] wrong ok, expected %v, got %v", name, item.OK, ok)
+		}
+		if ok && !reflect.DeepEqual(item.IDs, ids) {
+			t.Errorf("[%s] wrong result, expected %v, got %v", name, item.IDs, ids)
+		}
+	}
+}
+
+func TestScoreUsers(t *testing.T) {
+	users := Users{
+		{ID: 1, Name: "Boyd Wolf", About: "Lorem ipsum dolor sit amet, cillum."},
+		{ID: 2, Name: "Cillum Mayer", About: "Lorem ipsum dolor sit amet."},
+		{ID: 3, Name: "Hilda Mayer", About: "Cillum dolor cillum."},
+		{ID: 4, Name: "Owen Lynn", About: "Lorem ipsum."},
+	}
+	snapshot := &datasetSnapshot{users: slices.Clone(users), index: newUserIndex(users)}
+
+	root, err := parseQuery("cillum", matcher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scoreUsers(users, searchQuery{raw: "cillum", root: root}, snapshot)
+
+	if users[3].Score != 0 {
+		t.Errorf("user without match must have zero score, got %v", users[3].Score)
+	}
+	if users[1].Score <= users[0].Score {
+		t.Errorf("name match must rank above about match: %v <= %v", users[1].Score, users[0].Score)
+	}
+	if users[2].Score <= users[0].Score {
+		t.Errorf("more matches must rank higher: %v <= %v", users[2].Score, users[0].Score)
+	}
+
+	ids := []int{}
+	for _, user := range sortUsers(users, []sortKey{{field: OrderFieldRelevance, orderBy: OrderByDesc}}, nil) {
+		ids = append(ids, user.ID)
+	}
+	if !reflect.DeepEqual([]int{2, 3, 1, 4}, ids) {
+		t.Errorf("wrong relevance order: %v", ids)
+	}
+
+	// idf терма с полем считается по всему снимку: другие условия запроса на Score не влияют
+	root, err = parseQuery("about:cillum", matcher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := slices.Clone(snapshot.users)
+	scoreUsers(all, searchQuery{root: root}, snapshot)
+	filtered := Users{snapshot.users[0]}
+	scoreUsers(filtered, searchQuery{root: root}, snapshot)
+	if filtered[0].Score != all[0].Score || all[0].Score == 0 {
+		t.Errorf("score depends on filtered users: %v != %v", filtered[0].Score, all[0].Score)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// matcher сравнивает строки из запроса со значениями полей с учетом режима match
+type matcher struct {
+	fold func(r rune) rune // nil - точное сравнение
+}
+
+func newMatcher(mode string) (matcher, error) {
+	switch mode {
+	case MatchExact, "":
+		return matcher{}, nil
+	case MatchCI:
+		return matcher{fold: foldCase}, nil
+	case MatchFold:
+		return matcher{fold: foldRune}, nil
+	}
+
+	return matcher{}, fmt.Errorf(ErrorBadMatch)
+}
+
+// normalize приводит строку к виду, в котором ее можно сравнивать побайтно
+func (m matcher) normalize(s string) string {
+	if m.fold == nil {
+		return s
+	}
+
+	return strings.Map(m.fold, s)
+}
+
+// contains ищет sub в s; sub должен быть уже нормализован
+func (m matcher) contains(s, sub string) bool {
+	return strings.Contains(m.normalize(s), sub)
+}
+
+// equal сравнивает s с value целиком; value должен быть уже нормализован
+func (m matcher) equal(s, value string) bool {
+	return m.normalize(s) == value
+}
+
+// foldCase приводит руну к представителю ее класса эквивалентности по регистру
+func foldCase(r rune) rune {
+	folded := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < folded {
+			folded = f
+		}
+	}
+
+	return folded
+}
+
+// foldRune дополнительно к регистру убирает диакритику: "José" и "JOSE" совпадают.
+// Отдельные комбинируемые знаки (например, после "e" в NFD) выбрасываются.
+func foldRune(r rune) rune {
+	if unicode.Is(unicode.Mn, r) {
+		return -1
+	}
+	if base, ok := diacritics[r]; ok {
+		r = base
+	}
+
+	return foldCase(r)
+}
+
+var diacritics = func() map[rune]rune {
+	groups := map[rune]string{
+		'A': "ÀÁÂÃÄÅĀĂĄǍ", 'a': "àáâãäåāăąǎ",
+		'C': "ÇĆĈĊČ", 'c': "çćĉċč",
+		'D': "ĎĐ", 'd': "ďđ",
+		'E': "ÈÉÊËĒĔĖĘĚ", 'e': "èéêëēĕėęě",
+		'G': "ĜĞĠĢ", 'g': "ĝğġģ",
+		'H': "ĤĦ", 'h': "ĥħ",
+		'I': "ÌÍÎÏĨĪĬĮİǏ", 'i': "ìíîïĩīĭįıǐ",
+		'J': "Ĵ", 'j': "ĵ",
+		'K': "Ķ", 'k': "ķ",
+		'L': "ĹĻĽĿŁ", 'l': "ĺļľŀł",
+		'N': "ÑŃŅŇ", 'n': "ñńņň",
+		'O': "ÒÓÔÕÖØŌŎŐǑ", 'o': "òóôõöøōŏőǒ",
+		'R': "ŔŖŘ", 'r': "ŕŗř",
+		'S': "ŚŜŞŠ", 's': "śŝşš",
+		'T': "ŢŤŦ", 't': "ţťŧ",
+		'U': "ÙÚÛÜŨŪŬŮŰŲǓ", 'u': "ùúûüũūŭůűųǔ",
+		'W': "Ŵ", 'w': "ŵ",
+		'Y': "ÝŶŸ", 'y': "ýÿŷ",
+		'Z': "ŹŻŽ", 'z': "źżž",
+	}
+
+	table := make(map[rune]rune)
+	for base, letters := range groups {
+		for _, r := range letters {
+			table[r] = base
+		}
+	}
+
+	return table
+}()
+
+func parseMatchParam(r *http.Request) (matcher, error) {
+	return newMatcher(r.URL.Query().Get("match"))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Money - денежная сумма в центах, в текстовом виде выглядит как "$2,144.93"
+type Money int64
+
+func (m Money) String() string {
+	sign := ""
+	cents := int64(m)
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+
+	dollars := strconv.FormatInt(cents/100, 10)
+	groups := make([]string, 0, len(dollars)/3+1)
+	for len(dollars) > 3 {
+		groups = append([]string{dollars[len(dollars)-3:]}, groups...)
+		dollars = dollars[:len(dollars)-3]
+	}
+	groups = append([]string{dollars}, groups...)
+
+	return fmt.Sprintf("%s$%s.%02d", sign, strings.Join(groups, ","), cents%100)
+}
+
+func (m Money) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText разбирает суммы вида "$2,144.93", "-$3.5" и "1200"
+func (m *Money) UnmarshalText(text []byte) error {
+	value := strings.TrimSpace(string(text))
+
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimPrefix(value, "-")
+	value = strings.TrimPrefix(value, "$")
+	value = strings.ReplaceAll(value, ",", "")
+
+	dollars, fraction, _ := strings.Cut(value, ".")
+	if len(fraction) > 2 {
+		return fmt.Errorf("bad money value %q", text)
+	}
+	fraction += strings.Repeat("0", 2-len(fraction))
+
+	d, err := strconv.ParseUint(dollars, 10, 63)
+	if err != nil {
+		return fmt.Errorf("bad money value %q", text)
+	}
+	c, err := strconv.ParseUint(fraction, 10, 8)
+	if err != nil {
+		return fmt.Errorf("bad money value %q", text)
+	}
+
+	cents := int64(d*100 + c)
+	if negative {
+		cents = -cents
+	}
+	*m = Money(cents)
+
+	return nil
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Язык запросов параметра query:
+//
+//	cillum                  - подстрока в Name или About
+//	cill*                   - слово, начинающееся с cill
+//	"nisi mollit"           - фраза целиком
+//	name:boyd gender:female - подстрока (или точное значение) в конкретном поле
+//	age:>30 age:20..30      - сравнение и диапазон для чисел, сумм и дат
+//	a AND b, a OR b, NOT a  - логика, скобки для группировки; пробел между термами - это AND
+type queryNode interface {
+	match(user User) bool
+}
+
+type andNode struct {
+	left, right queryNode
+}
+
+func (n andNode) match(user User) bool {
+	return n.left.match(user) && n.right.match(user)
+}
+
+type orNode struct {
+	left, right queryNode
+}
+
+func (n orNode) match(user User) bool {
+	return n.left.match(user) || n.right.match(user)
+}
+
+type notNode struct {
+	node queryNode
+}
+
+func (n notNode) match(user User) bool {
+	return !n.node.match(user)
+}
+
+// textNode ищет text в строковом поле; без поля - в Name или About, как раньше.
+// text хранится уже нормализованным под matcher.
+type textNode struct {
+	field   *userField
+	text    string
+	matcher matcher
+}
+
+func (n textNode) match(user User) bool {
+	if n.field == nil {
+		return n.matcher.contains(user.Name, n.text) || n.matcher.contains(user.About, n.text)
+	}
+
+	value := n.field.value(user).(string)
+	if n.field.keyword {
+		return n.matcher.equal(value, n.text)
+	}
+
+	return n.matcher.contains(value, n.text)
+}
+
+// prefixNode ищет в строковом поле слово, начинающееся с prefix; без поля - в Name или About
+type prefixNode struct {
+	field   *userField
+	prefix  string
+	matcher matcher
+}
+
+func (n prefixNode) match(user User) bool {
+	if n.field == nil {
+		return n.hasPrefix(user.Name) || n.hasPrefix(user.About)
+	}
+
+	return n.hasPrefix(n.field.value(user).(string))
+}
+
+func (n prefixNode) hasPrefix(s string) bool {
+	words := strings.FieldsFunc(n.matcher.normalize(s), func(r rune) bool {
+		return !isTermRune(r)
+	})
+	for _, word := range words {
+		if strings.HasPrefix(word, n.prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compareNode сравнивает нестроковое поле с границами from и to (включительно), nil - без границы
+type compareNode struct {
+	field    *userField
+	from, to interface{}
+	// строгие границы для > и <
+	fromExclusive, toExclusive bool
+}
+
+func (n compareNode) match(user User) bool {
+	value := n.field.value(user)
+	if n.from != nil {
+		c := compareValues(value, n.from)
+		if c < 0 || c == 0 && n.fromExclusive {
+			return false
+		}
+	}
+	if n.to != nil {
+		c := compareValues(value, n.to)
+		if c > 0 || c == 0 && n.toExclusive {
+			return false
+		}
+	}
+
+	return true
+}
+
+// compareValues сравнивает значения одного типа из userFields
+func compareValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case int:
+		return cmp.Compare(a, b.(int))
+	case Money:
+		return cmp.Compare(a, b.(Money))
+	case bool:
+		return cmp.Compare(boolToInt(a), boolToInt(b.(bool)))
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	case string:
+		return strings.Compare(a, b.(string))
+	case float64:
+		return cmp.Compare(a, b.(float64))
+	}
+
+	return 0
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// parseQuery разбирает query в дерево, строки в котором сравниваются через m.
+// Пустой запрос дает nil - подходит любой пользователь.
+func parseQuery(query string, m matcher) (queryNode, error) {
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	p := &queryParser{tokens: tokens, matcher: m}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+
+	return node, nil
+}
+
+const (
+	tokenTerm = iota
+	tokenLParen
+	tokenRParen
+	tokenAnd
+	tokenOr
+	tokenNot
+)
+
+type queryToken struct {
+	kind   int
+	field  string
+	text   string
+	quoted bool
+}
+
+func lexQuery(query string) ([]queryToken, error) {
+	tokens := []queryToken{}
+	for i := 0; i < len(query); {
+		switch c := query[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, queryToken{kind: tokenLParen, text: "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, queryToken{kind: tokenRParen, text: ")"})
+			i++
+		case c == '"':
+			phrase, n, err := readPhrase(query[i:])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, queryToken{kind: tokenTerm, text: phrase, quoted: true})
+			i += n
+		default:
+			j := i
+			for j < len(query) && !strings.ContainsRune(" \t\n\r()\"", rune(query[j])) {
+				j++
+			}
+			word := query[i:j]
+			i = j
+
+			switch word {
+			case "AND":
+				tokens = append(tokens, queryToken{kind: tokenAnd, text: word})
+				continue
+			case "OR":
+				tokens = append(tokens, queryToken{kind: tokenOr, text: word})
+				continue
+			case "NOT":
+				tokens = append(tokens, queryToken{kind: tokenNot, text: word})
+				continue
+			}
+
+			field, value, found := strings.Cut(word, ":")
+			if !found || len(field) == 0 {
+				tokens = append(tokens, queryToken{kind: tokenTerm, text: word})
+				continue
+			}
+
+			token := queryToken{kind: tokenTerm, field: field, text: value}
+			if len(value) == 0 && i < len(query) && query[i] == '"' {
+				phrase, n, err := readPhrase(query[i:])
+				if err != nil {
+					return nil, err
+				}
+				token.text, token.quoted = phrase, true
+				i += n
+			}
+			tokens = append(tokens, token)
+		}
+	}
+
+	return tokens, nil
+}
+
+// readPhrase читает строку в кавычках с начала s, внутри допускается \" и \\
+func readPhrase(s string) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), i + 1, nil
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+
+	return "", 0, fmt.Errorf("unterminated phrase")
+}
+
+type queryParser struct {
+	tokens  []queryToken
+	pos     int
+	matcher matcher
+}
+
+func (p *queryParser) peek() (queryToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return queryToken{}, false
+	}
+
+	return p.tokens[p.pos], true
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		token, ok := p.peek()
+		if !ok || token.kind != tokenOr {
+			return left, nil
+		}
+		p.pos++
+
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left: left, right: right}
+	}
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		token, ok := p.peek()
+		if !ok || token.kind == tokenOr || token.kind == tokenRParen {
+			return left, nil
+		}
+		if token.kind == tokenAnd {
+			p.pos++
+		}
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left: left, right: right}
+	}
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	token, ok := p.peek()
+	if ok && token.kind == tokenNot {
+		p.pos++
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node: node}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (queryNode, error) {
+	token, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("unexpected end of query")
+	}
+	p.pos++
+
+	switch token.kind {
+	case tokenLParen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing, ok := p.peek(); !ok || closing.kind != tokenRParen {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return node, nil
+	case tokenTerm:
+		return newTermNode(token, p.matcher)
+	}
+
+	return nil, fmt.Errorf("unexpected %q", token.text)
+}
+
+func newTermNode(token queryToken, m matcher) (queryNode, error) {
+	if len(token.field) == 0 {
+		if len(token.text) == 0 {
+			return nil, fmt.Errorf("empty term")
+		}
+		if prefix, ok := termPrefix(token); ok {
+			return prefixNode{prefix: m.normalize(prefix), matcher: m}, nil
+		}
+		return textNode{text: m.normalize(token.text), matcher: m}, nil
+	}
+
+	field, ok := userFields[strings.ToLower(token.field)]
+	if !ok || field.value == nil {
+		return nil, fmt.Errorf("unknown field %q", token.field)
+	}
+	if len(token.text) == 0 {
+		return nil, fmt.Errorf("empty value for field %q", token.field)
+	}
+
+	sample := field.value(User{})
+	if _, ok := sample.(string); ok {
+		if prefix, ok := termPrefix(token); ok {
+			return prefixNode{field: &field, prefix: m.normalize(prefix), matcher: m}, nil
+		}
+		return textNode{field: &field, text: m.normalize(token.text), matcher: m}, nil
+	}
+	if token.quoted {
+		return nil, fmt.Errorf("field %q does not accept phrases", token.field)
+	}
+
+	return newCompareNode(&field, sample, token.text)
+}
+
+// termPrefix выделяет префикс из терма вида cill*; в кавычках звездочка остается обычным символом
+func termPrefix(token queryToken) (string, bool) {
+	if token.quoted || len(token.text) < 2 || !strings.HasSuffix(token.text, "*") {
+		return "", false
+	}
+
+	return strings.TrimSuffix(token.text, "*"), true
+}
+
+func newCompareNode(field *userField, sample interface{}, text string) (queryNode, error) {
+	node := compareNode{field: field}
+
+	if from, to, found := strings.Cut(text, ".."); found {
+		var err error
+		if node.from, err = parseFieldValue(sample, from); err != nil {
+			return nil, err
+		}
+		if node.to, err = parseFieldValue(sample, to); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+
+	op := ""
+	for _, prefix := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(text, prefix) {
+			op, text = prefix, text[len(prefix):]
+			break
+		}
+	}
+
+	value, err := parseFieldValue(sample, text)
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case ">":
+		node.from, node.fromExclusive = value, true
+	case ">=":
+		node.from = value
+	case "<":
+		node.to, node.toExclusive = value, true
+	case "<=":
+		node.to = value
+	default:
+		node.from, node.to = value, value
+	}
+
+	return node, nil
+}
+
+// parseFieldValue приводит текст из запроса к типу значения sample
+func parseFieldValue(sample interface{}, text string) (interface{}, error) {
+	switch sample.(type) {
+	case int:
+		return strconv.Atoi(text)
+	case Money:
+		var money Money
+		err := money.UnmarshalText([]byte(text))
+		return money, err
+	case bool:
+		return strconv.ParseBool(text)
+	case time.Time:
+		if value, err := time.Parse(time.DateOnly, text); err == nil {
+			return value, nil
+		}
+		return time.Parse(time.RFC3339, text)
+	}
+
+	return nil, fmt.Errorf("unsupported field type %T", sample)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	users, _, err := loadUsers("dataset.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]struct {
+		Query   string
+		IDs     []int
+		IsError bool
+	}{
+		"field substring":    {Query: "name:Guerr", IDs: []int{11, 12}},
+		"keyword field":      {Query: "gender:female age:>35", IDs: []int{9, 32, 33}},
+		"range":              {Query: "age:39..40", IDs: []int{6, 13, 26, 32}},
+		"or with not":        {Query: "age:40 OR (age:39 AND NOT is_active:true)", IDs: []int{6, 13, 32}},
+		"explicit and":       {Query: "eye_color:brown AND gender:male", IDs: []int{12, 23}},
+		"money":              {Query: "balance:<1120", IDs: []int{2, 13}},
+		"date":               {Query: "registered:>=2017-04-01", IDs: []int{8, 23}},
+		"quoted phrase":      {Query: `"Nulla cillum enim"`, IDs: []int{0}},
+		"field phrase":       {Query: `name:"Boyd Wolf"`, IDs: []int{0}},
+		"nothing found":      {Query: "qwerty", IDs: []int{}},
+		"unknown field":      {Query: "password:123", IsError: true},
+		"bad number":         {Query: "age:>old", IsError: true},
+		"missing paren":      {Query: "(age:40 OR age:39", IsError: true},
+		"extra paren":        {Query: "age:40)", IsError: true},
+		"dangling operator":  {Query: "age:40 AND", IsError: true},
+		"unterminated quote": {Query: `"nisi mollit`, IsError: true},
+		"empty value":        {Query: "name:", IsError: true},
+	}
+
+	for name, item := range cases {
+		root, err := parseQuery(item.Query, matcher{})
+		if err != nil && !item.IsError {
+			t.Errorf("[%s] unexpected error: %v", name, err)
+		}
+		if err == nil && item.IsError {
+			t.Errorf("[%s] expected error, got nil", name)
+		}
+		if err != nil {
+			continue
+		}
+
+		ids := []int{}
+		for _, user := range users {
+			if root.match(user) {
+				ids = append(ids, user.ID)
+			}
+		}
+		if !reflect.DeepEqual(item.IDs, ids) {
+			t.Errorf("[%s] wrong result, expected %v, got %v", name, item.IDs, ids)
+		}
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	users := Users{
+		{ID: 1, Name: "José Álvarez", Gender: "male"},
+		{ID: 2, Name: "JOSE ALVAREZ", Gender: "Male"},
+		{ID: 3, Name: "Jose\u0301 alvarez", Gender: "male"},
+		{ID: 4, Name: "Joseph Smith", Gender: "female"},
+	}
+
+	cases := map[string]struct {
+		Mode  string
+		Query string
+		IDs   []int
+	}{
+		"exact":          {Mode: MatchExact, Query: "José", IDs: []int{1}},
+		"exact keyword":  {Mode: MatchExact, Query: "gender:male", IDs: []int{1, 3}},
+		"ci":             {Mode: MatchCI, Query: "jose", IDs: []int{2, 3, 4}},
+		"ci keyword":     {Mode: MatchCI, Query: "gender:MALE", IDs: []int{1, 2, 3}},
+		"fold":           {Mode: MatchFold, Query: "jose alvarez", IDs: []int{1, 2, 3}},
+		"fold accented":  {Mode: MatchFold, Query: "ÁLVAREZ", IDs: []int{1, 2, 3}},
+		"ci keeps marks": {Mode: MatchCI, Query: "álvarez", IDs: []int{1}},
+	}
+
+	for name, item := range cases {
+		m, err := newMatcher(item.Mode)
+		if err != nil {
+			t.Fatalf("[%s] unexpected error: %v", name, err)
+		}
+		root, err := parseQuery(item.Query, m)
+		if err != nil {
+			t.Fatalf("[%s] unexpected error: %v", name, err)
+		}
+
+		ids := []int{}
+		for _, user := range users {
+			if root.match(user) {
+				ids = append(ids, user.ID)
+			}
+		}
+		if !reflect.DeepEqual(item.IDs, ids) {
+			t.Errorf("[%s] wrong result, expected %v, got %v", name, item.IDs, ids)
+		}
+	}
+
+	if _, err := newMatcher("soundex"); err == nil {
+		t.Error("expected error for unknown match mode, got nil")
+	}
+}
+
+func TestHighlightUsers(t *testing.T) {
+	about := "Lorem ipsum dolor sit amet, consectetur adipisicing elit. Sed do eiusmod tempor incididunt ut labore et dolore magna aliqua. Dolor in reprehenderit."
+	users := Users{
+		{ID: 1, Name: "José Dolores", About: about},
+		{ID: 2, Name: "Boyd Wolf", About: "Nothing here"},
+	}
+
+	m, _ := newMatcher(MatchFold) //nolint:errcheck
+	root, err := parseQuery("jose OR dolor*", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	highlights := highlightUsers(users, searchQuery{root: root})
+
+	if len(highlights[1]) != 0 {
+		t.Errorf("unexpected highlights for user without matches: %#v", highlights[1])
+	}
+
+	expected := []Highlight{
+		{
+			Field:    "Name",
+			Fragment: "José Dolores",
+			Matches: []HighlightMatch{
+				{Start: 0, End: 5, RuneStart: 0, RuneEnd: 4},
+				{Start: 6, End: 11, RuneStart: 5, RuneEnd: 10},
+			},
+		},
+		{
+			Field:    "About",
+			Fragment: "Lorem ipsum dolor sit amet, consectetur adipisicing elit.",
+			Matches: []HighlightMatch{
+				{Start: 12, End: 17, RuneStart: 12, RuneEnd: 17},
+			},
+		},
+		{
+			Field:    "About",
+			Fragment: " eiusmod tempor incididunt ut labore et dolore magna aliqua. Dolor in reprehenderit.",
+			Matches: []HighlightMatch{
+				{Start: 40, End: 45, RuneStart: 40, RuneEnd: 45},
+				{Start: 61, End: 66, RuneStart: 61, RuneEnd: 66},
+			},
+		},
+	}
+	if !reflect.DeepEqual(expected, highlights[0]) {
+		t.Errorf("wrong highlights, expected %#v, got %#v", expected, highlights[0])
+	}
+
+	// терм с полем подсвечивается и ранжируется только в своем поле
+	fields := map[string][]string{
+		"name:dolor*": {"Name"},
+		"about:dolor": {"About", "About"},
+		"about:boyd":  {},
+	}
+	for raw, expectedFields := range fields {
+		root, err := parseQuery(raw, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, userHighlights := range highlightUsers(users, searchQuery{root: root}) {
+			for _, h := range userHighlights {
+				got = append(got, h.Field)
+			}
+		}
+		if !reflect.DeepEqual(expectedFields, got) {
+			t.Errorf("[%s] wrong highlighted fields, expected %v, got %v", raw, expectedFields, got)
+		}
+	}
+
+	root, err = parseQuery("about:boyd", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scored := slices.Clone(users)
+	scoreUsers(scored, searchQuery{root: root}, &datasetSnapshot{users: users, index: newUserIndex(users)})
+	if scored[1].Score != 0 {
+		t.Errorf("about:boyd must not score a match in Name: %v", scored[1].Score)
+	}
+}
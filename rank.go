@@ -0,0 +1,162 @@
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+// Параметры BM25. Совпадение в Name весит как nameWeight совпадений в About.
+const (
+	bm25K1     = 1.2
+	bm25B      = 0.75
+	nameWeight = 3
+)
+
+// rankTerm - терм запроса, по которому считается релевантность
+type rankTerm struct {
+	text   string // нормализован как слова индекса
+	prefix bool
+	field  string // "Name", "About" или пусто - искать в обоих
+}
+
+// positiveTerms собирает textNode и prefixNode, которые ищутся в Name или About.
+// Термы под NOT и из других полей не учитываются.
+func positiveTerms(node queryNode) []queryNode {
+	switch n := node.(type) {
+	case textNode:
+		if n.field == nil || n.field.key == "Name" || n.field.key == "About" {
+			return []queryNode{n}
+		}
+	case prefixNode:
+		if n.field == nil || n.field.key == "Name" || n.field.key == "About" {
+			return []queryNode{n}
+		}
+	case andNode:
+		return append(positiveTerms(n.left), positiveTerms(n.right)...)
+	case orNode:
+		return append(positiveTerms(n.left), positiveTerms(n.right)...)
+	}
+
+	return nil
+}
+
+// termField - поле, к которому привязан терм из positiveTerms, или пусто для терма без поля
+func termField(node queryNode) string {
+	var field *userField
+	switch n := node.(type) {
+	case textNode:
+		field = n.field
+	case prefixNode:
+		field = n.field
+	}
+	if field == nil {
+		return ""
+	}
+
+	return field.key
+}
+
+func rankTerms(node queryNode) []rankTerm {
+	terms := []rankTerm{}
+	for _, term := range positiveTerms(node) {
+		switch n := term.(type) {
+		case textNode:
+			terms = append(terms, rankTerm{text: indexNormalize(n.text), field: termField(n)})
+		case prefixNode:
+			terms = append(terms, rankTerm{text: indexNormalize(n.prefix), prefix: true, field: termField(n)})
+		}
+	}
+
+	return terms
+}
+
+// scoreUsers проставляет пользователям Score по BM25 с учетом веса Name.
+// Статистика термов берется по всему снимку, а не по users, чтобы прочие условия запроса не меняли idf
+func scoreUsers(users Users, query searchQuery, snapshot *datasetSnapshot) {
+	idx := snapshot.index
+	terms := rankTerms(query.root)
+	if len(terms) == 0 || idx == nil || idx.docs == 0 {
+		return
+	}
+
+	idf := make([]float64, len(terms))
+	for i, term := range terms {
+		// индекс общий для Name и About, для терма одного поля считаем по пользователям снимка
+		df := -1
+		if len(term.field) == 0 {
+			df = idx.docFrequency(term)
+		}
+		if df < 0 {
+			df = 0
+			for _, user := range snapshot.users {
+				if userFrequency(user, term) > 0 {
+					df++
+				}
+			}
+		}
+		n := float64(idx.docs)
+		idf[i] = math.Log(1 + (n-float64(df)+0.5)/(float64(df)+0.5))
+	}
+
+	for i := range users {
+		length := float64(nameWeight*len(indexTerms(users[i].Name)) + len(indexTerms(users[i].About)))
+		norm := bm25K1 * (1 - bm25B + bm25B*length/idx.avgLength)
+
+		score := 0.0
+		for j, term := range terms {
+			tf := float64(userFrequency(users[i], term))
+			if tf > 0 {
+				score += idf[j] * tf * (bm25K1 + 1) / (tf + norm)
+			}
+		}
+		users[i].Score = math.Round(score*1e4) / 1e4
+	}
+}
+
+// userFrequency - частота терма в Name (с весом nameWeight) и About, если терм в них ищется
+func userFrequency(user User, term rankTerm) int {
+	tf := 0
+	if len(term.field) == 0 || term.field == "Name" {
+		tf += nameWeight * termFrequency(user.Name, term)
+	}
+	if len(term.field) == 0 || term.field == "About" {
+		tf += termFrequency(user.About, term)
+	}
+
+	return tf
+}
+
+// termFrequency считает вхождения терма в текст: для слова - число слов, содержащих его, для фразы - число вхождений
+func termFrequency(text string, term rankTerm) int {
+	if len(term.text) == 0 {
+		return 0
+	}
+	if !term.prefix && strings.IndexFunc(term.text, func(r rune) bool { return !isTermRune(r) }) >= 0 {
+		return strings.Count(indexNormalize(text), term.text)
+	}
+
+	count := 0
+	for _, word := range indexTerms(text) {
+		if term.prefix && strings.HasPrefix(word, term.text) || !term.prefix && strings.Contains(word, term.text) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// docFrequency возвращает число пользователей с термом или -1, если индекс его не обслуживает
+func (idx *userIndex) docFrequency(term rankTerm) int {
+	var ids []int
+	var ok bool
+	if term.prefix {
+		ids, ok = idx.prefix(term.text)
+	} else {
+		ids, ok = idx.substring(term.text)
+	}
+	if !ok {
+		return -1
+	}
+
+	return len(ids)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy - когда и как часто SearchClient повторяет запрос.
+// Повторяются таймауты попытки, ошибки соединения и ответы 500, 502, 503, 429.
+type RetryPolicy struct {
+	// всего попыток вместе с первой, меньше 2 - без повторов
+	MaxAttempts int
+	// пауза перед первым повтором, дальше удваивается до MaxDelay
+	BaseDelay time.Duration
+	// потолок паузы, 0 - без потолка. Если Retry-After от сервера просит ждать дольше
+	// (а при MaxDelay 0 - дольше maxRetryAfter), запрос не повторяется
+	MaxDelay time.Duration
+}
+
+// maxRetryAfter - дольше этого Retry-After не ждем, если у RetryPolicy нет MaxDelay
+const maxRetryAfter = time.Minute
+
+// next решает, нужен ли еще один заход после попытки attempt, и сколько перед ним ждать
+func (p *RetryPolicy) next(ctx context.Context, attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	if p == nil || attempt >= p.MaxAttempts || ctx.Err() != nil {
+		return 0, false
+	}
+
+	if err != nil {
+		return p.backoff(attempt), isRetryableError(err)
+	}
+	if !isRetryableStatus(resp.StatusCode) {
+		return 0, false
+	}
+	if delay, ok := retryAfter(resp.Header, time.Now()); ok {
+		return delay, delay <= p.retryAfterLimit()
+	}
+
+	return p.backoff(attempt), true
+}
+
+// retryAfterLimit - самый долгий Retry-After, который клиент готов переждать
+func (p *RetryPolicy) retryAfterLimit() time.Duration {
+	if p.MaxDelay > 0 {
+		return p.MaxDelay
+	}
+
+	return maxRetryAfter
+}
+
+// backoff - экспоненциальная пауза со случайным разбросом в пределах ее второй половины,
+// чтобы клиенты, получившие отказ одновременно, не повторяли запрос тоже одновременно
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func isRetryableStatus(status int) bool {
+	switch status {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusTooManyRequests:
+		return true
+	}
+
+	return false
+}
+
+// isRetryableError отделяет сбои сети от ошибок, которые повтор не исправит (например, неверный URL)
+func isRetryableError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// retryAfter разбирает заголовок Retry-After: число секунд или дата
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	value := header.Get("Retry-After")
+	if len(value) == 0 {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+
+	return 0, false
+}
+
+// sleepContext ждет delay или отмены ctx
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
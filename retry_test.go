@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyServer отвечает статусами из failures по очереди, а потом работает как SearchServer
+func flakyServer(failures []int, header http.Header) (*httptest.Server, *atomic.Int32) {
+	calls := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1))
+		if n > len(failures) {
+			SearchServer(w, r)
+			return
+		}
+
+		status := failures[n-1]
+		if status == 0 {
+			// имитируем обрыв соединения
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		for key, values := range header {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(status)
+	}))
+
+	return server, calls
+}
+
+func TestFindUsersRetry(t *testing.T) {
+	FileDataset = "dataset.xml"
+	policy := &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+	cases := map[string]struct {
+		Failures []int
+		Header   http.Header
+		Policy   *RetryPolicy
+		Calls    int32
+		Target   error
+	}{
+		"recovers after 500":      {Failures: []int{500, 500}, Policy: policy, Calls: 3},
+		"recovers after 502, 503": {Failures: []int{502, 503}, Policy: policy, Calls: 3},
+		"recovers after drop":     {Failures: []int{0}, Policy: policy, Calls: 2},
+		"retry after header":      {Failures: []int{429}, Header: http.Header{"Retry-After": {"0"}}, Policy: policy, Calls: 2},
+		"retry after too long":    {Failures: []int{429}, Header: http.Header{"Retry-After": {"3600"}}, Policy: policy, Calls: 1, Target: ErrTooManyRequests},
+		"retry after no max":      {Failures: []int{503}, Header: http.Header{"Retry-After": {"3600"}}, Policy: &RetryPolicy{MaxAttempts: 3}, Calls: 1, Target: ErrServerUnavailable},
+		"gives up":                {Failures: []int{503, 503, 503, 503}, Policy: policy, Calls: 3, Target: ErrServerUnavailable},
+		"too many requests":       {Failures: []int{429, 429, 429}, Policy: policy, Calls: 3, Target: ErrTooManyRequests},
+		"no retry on 401":         {Failures: []int{401}, Policy: policy, Calls: 1, Target: ErrBadAccessToken},
+		"no retry on 404":         {Failures: []int{404, 404}, Policy: policy, Calls: 1, Target: ErrUnexpectedStatus},
+		"no policy":               {Failures: []int{500}, Calls: 1, Target: ErrServerFatal},
+	}
+
+	for name, item := range cases {
+		server, calls := flakyServer(item.Failures, item.Header)
+		client := &SearchClient{AccessToken: "token", URL: server.URL, Retry: item.Policy}
+		_, err := client.FindUsers(SearchRequest{Limit: 2})
+		server.Close()
+
+		switch {
+		case item.Target != nil && !errors.Is(err, item.Target):
+			t.Errorf("[%s] expected %v, got %v", name, item.Target, err)
+		case item.Target == nil && item.Calls > 1 && err != nil:
+			t.Errorf("[%s] unexpected error: %v", name, err)
+		}
+		if got := calls.Load(); got != item.Calls {
+			t.Errorf("[%s] wrong attempts count: got %d want %d", name, got, item.Calls)
+		}
+	}
+}
+
+func TestFindUsersRetryTimeout(t *testing.T) {
+	FileDataset = "dataset.xml"
+
+	calls := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			<-r.Context().Done()
+			return
+		}
+		SearchServer(w, r)
+	}))
+	defer server.Close()
+
+	client := &SearchClient{
+		AccessToken: "token",
+		URL:         server.URL,
+		Timeout:     50 * time.Millisecond,
+		Retry:       &RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+	}
+	resp, err := client.FindUsers(SearchRequest{Limit: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resp.Users) != 2 || calls.Load() != 2 {
+		t.Errorf("expected second attempt to succeed: %d users, %d calls", len(resp.Users), calls.Load())
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := &RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+
+	cases := map[int]struct {
+		Min, Max time.Duration
+	}{
+		1: {Min: 5 * time.Millisecond, Max: 10 * time.Millisecond},
+		2: {Min: 10 * time.Millisecond, Max: 20 * time.Millisecond},
+		3: {Min: 20 * time.Millisecond, Max: 40 * time.Millisecond},
+		4: {Min: 25 * time.Millisecond, Max: 50 * time.Millisecond},
+		9: {Min: 25 * time.Millisecond, Max: 50 * time.Millisecond},
+	}
+
+	for attempt, item := range cases {
+		for i := 0; i < 20; i++ {
+			if delay := policy.backoff(attempt); delay < item.Min || delay > item.Max {
+				t.Errorf("[attempt %d] delay %v out of [%v, %v]", attempt, delay, item.Min, item.Max)
+			}
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	cases := map[string]struct {
+		Value string
+		Delay time.Duration
+		OK    bool
+	}{
+		"seconds":  {Value: "3", Delay: 3 * time.Second, OK: true},
+		"date":     {Value: "Fri, 01 Mar 2024 12:00:10 GMT", Delay: 10 * time.Second, OK: true},
+		"past":     {Value: "Fri, 01 Mar 2024 11:00:00 GMT", Delay: 0, OK: true},
+		"missing":  {Value: "", OK: false},
+		"garbage":  {Value: "soon", OK: false},
+		"negative": {Value: "-1", OK: false},
+	}
+
+	for name, item := range cases {
+		header := http.Header{}
+		if len(item.Value) > 0 {
+			header.Set("Retry-After", item.Value)
+		}
+		delay, ok := retryAfter(header, now)
+		if ok != item.OK || delay != item.Delay {
+			t.Errorf("[%s] got %v, %v want %v, %v", name, delay, ok, item.Delay, item.OK)
+		}
+	}
+}
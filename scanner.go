@@ -0,0 +1,83 @@
+package main
+
+import "context"
+
+// UserScanner обходит всю выдачу по одному пользователю, подгружая страницы по мере надобности:
+//
+//	scanner := client.Scan(ctx, SearchRequest{Limit: 25, Query: "cillum"})
+//	for scanner.Next() {
+//		user := scanner.User()
+//	}
+//	if err := scanner.Err(); err != nil {
+//		...
+//	}
+type UserScanner struct {
+	ctx    context.Context
+	client *SearchClient
+	req    SearchRequest
+
+	page []User
+	pos  int
+	user User
+	// последняя страница уже получена
+	done bool
+	err  error
+}
+
+// Scan возвращает сканер выдачи req. Страницы идут через курсоры, поэтому строки,
+// добавленные во время обхода, не вызывают повторов. Отмена ctx останавливает обход.
+// Limit задает размер страницы, 0 - MaxLimit.
+func (srv *SearchClient) Scan(ctx context.Context, req SearchRequest) *UserScanner {
+	if req.Limit == 0 {
+		req.Limit = MaxLimit
+	}
+
+	return &UserScanner{ctx: ctx, client: srv, req: req}
+}
+
+// Next переходит к следующему пользователю; false - выдача закончилась или случилась ошибка, см. Err
+func (s *UserScanner) Next() bool {
+	for s.err == nil {
+		if err := s.ctx.Err(); err != nil {
+			s.err = err
+			return false
+		}
+		if s.pos < len(s.page) {
+			s.user = s.page[s.pos]
+			s.pos++
+			return true
+		}
+		if s.done {
+			return false
+		}
+		s.fetch()
+	}
+
+	return false
+}
+
+func (s *UserScanner) fetch() {
+	resp, err := s.client.FindUsersContext(s.ctx, s.req)
+	if err != nil {
+		if ctxErr := s.ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		s.err = err
+		return
+	}
+
+	s.page, s.pos = resp.Users, 0
+	s.done = !resp.NextPage
+	s.req.Cursor = resp.NextCursor
+	s.req.Offset = 0
+}
+
+// User возвращает пользователя, на котором остановился Next
+func (s *UserScanner) User() User {
+	return s.user
+}
+
+// Err возвращает ошибку, на которой остановился обход, или nil, если выдача пройдена до конца
+func (s *UserScanner) Err() error {
+	return s.err
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+// benchUsersCount - размер сгенерированного набора для бенчмарков конвейера поиска
+const benchUsersCount = 100000
+
+var benchWords = []string{"lorem", "ipsum", "dolor", "cillum", "nisi", "mollit", "velit", "magna", "culpa", "anim", "sint", "tempor"}
+
+// generateUsers собирает детерминированный набор пользователей заданного размера
+func generateUsers(n int) Users {
+	rnd := rand.New(rand.NewSource(1))
+	users := make(Users, 0, n)
+	for i := 0; i < n; i++ {
+		about := ""
+		for j := 0; j < 8; j++ {
+			about += benchWords[rnd.Intn(len(benchWords))] + " "
+		}
+		users = append(users, User{
+			ID:         i,
+			Name:       fmt.Sprintf("%s user%d", benchWords[rnd.Intn(len(benchWords))], rnd.Intn(n)),
+			Age:        18 + rnd.Intn(60),
+			About:      about,
+			Gender:     []string{"male", "female"}[rnd.Intn(2)],
+			Balance:    Money(rnd.Int63n(400000)),
+			Registered: time.Date(2014, time.January, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(rnd.Intn(1e6)) * time.Minute),
+		})
+	}
+
+	return users
+}
+
+func benchSnapshot(b *testing.B) *datasetSnapshot {
+	b.Helper()
+	users := generateUsers(benchUsersCount)
+	return &datasetSnapshot{users: users, index: newUserIndex(users)}
+}
+
+func benchParams(b *testing.B, query string) searchParams {
+	b.Helper()
+	root, err := parseQuery(query, matcher{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	collate, _ := newCollator(CollationBinary) //nolint:errcheck
+
+	return searchParams{
+		limit:    25,
+		offset:   50,
+		sortKeys: []sortKey{{field: OrderFieldAge, orderBy: OrderByDesc}, {field: OrderFieldName, orderBy: OrderByAsc}},
+		collate:  collate,
+		query:    searchQuery{raw: query, root: root},
+	}
+}
+
+// legacySearchUsers - прежний порядок обработчика: полная сортировка всего набора, потом фильтр
+func legacySearchUsers(snapshot *datasetSnapshot, params searchParams) Users {
+	users := slices.Clone(snapshot.users)
+	sortUsers(users, params.sortKeys, params.collate)
+	users = queryUsers(users, params.query)
+
+	return limitOffsetUsers(users, params.limit, params.offset)
+}
+
+func BenchmarkSearchPipeline(b *testing.B) {
+	snapshot := benchSnapshot(b)
+	for _, query := range []string{"", "cillum", "age:>60"} {
+		params := benchParams(b, query)
+		b.Run(fmt.Sprintf("query=%q", query), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				searchUsers(snapshot, params)
+			}
+		})
+	}
+}
+
+func BenchmarkSearchLegacy(b *testing.B) {
+	snapshot := benchSnapshot(b)
+	for _, query := range []string{"", "cillum", "age:>60"} {
+		params := benchParams(b, query)
+		b.Run(fmt.Sprintf("query=%q", query), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				legacySearchUsers(snapshot, params)
+			}
+		})
+	}
+}
+
+func TestTopUsers(t *testing.T) {
+	users := generateUsers(1000)
+
+	cases := map[string]struct {
+		Keys []sortKey
+		K    int
+	}{
+		"age desc":        {Keys: []sortKey{{field: OrderFieldAge, orderBy: OrderByDesc}}, K: 30},
+		"gender and name": {Keys: []sortKey{{field: "gender", orderBy: OrderByAsc}, {field: OrderFieldName, orderBy: OrderByDesc}}, K: 75},
+		"stable ties":     {Keys: []sortKey{{field: "gender", orderBy: OrderByAsc}}, K: 10},
+		"zero":            {Keys: []sortKey{{field: OrderFieldAge, orderBy: OrderByAsc}}, K: 0},
+		"all":             {Keys: []sortKey{{field: OrderFieldAge, orderBy: OrderByAsc}}, K: 2000},
+	}
+
+	for name, item := range cases {
+		expected := sortUsers(slices.Clone(users), item.Keys, nil)
+		expected = expected[:min(item.K, len(expected))]
+
+		got := topUsers(slices.Clone(users), item.Keys, nil, item.K)
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("[%s] top differs from full sort prefix", name)
+		}
+	}
+}
+
+func TestSearchUsersMatchesLegacy(t *testing.T) {
+	users := generateUsers(2000)
+	snapshot := &datasetSnapshot{users: users, index: newUserIndex(users)}
+
+	for _, query := range []string{"", "cillum", "age:>60", "NOT nisi"} {
+		root, err := parseQuery(query, matcher{})
+		if err != nil {
+			t.Fatalf("[%s] unexpected error: %v", query, err)
+		}
+		for _, offset := range []int{0, 10, 1990, 5000} {
+			params := searchParams{
+				limit:    25,
+				offset:   offset,
+				sortKeys: []sortKey{{field: OrderFieldAge, orderBy: OrderByAsc}, {field: OrderFieldID, orderBy: OrderByDesc}},
+				query:    searchQuery{raw: query, root: root},
+			}
+
+			expected := legacySearchUsers(snapshot, params)
+			got, err := searchUsers(snapshot, params)
+			if err != nil {
+				t.Fatalf("[%s offset %d] unexpected error: %v", query, offset, err)
+			}
+			if !reflect.DeepEqual(expected, got.users) {
+				t.Errorf("[%s offset %d] pipeline result differs from sort-then-filter", query, offset)
+			}
+		}
+	}
+}
@@ -1,13 +1,16 @@
 package main
 
 import (
+	"cmp"
+	"container/heap"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
-	"sort"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -17,10 +20,6 @@ import (
 
 var FileDataset = "dataset.xml"
 
-type Dataset struct {
-	Rows []Row `xml:"row"`
-}
-
 type Row struct {
 	ID        int    `xml:"id"`
 	FirstName string `xml:"first_name"`
@@ -28,6 +27,57 @@ type Row struct {
 	Age       int    `xml:"age"`
 	About     string `xml:"about"`
 	Gender    string `xml:"gender"`
+
+	GUID          string      `xml:"guid"`
+	IsActive      bool        `xml:"isActive"`
+	Balance       Money       `xml:"balance"`
+	Picture       string      `xml:"picture"`
+	EyeColor      string      `xml:"eyeColor"`
+	Company       string      `xml:"company"`
+	Email         string      `xml:"email"`
+	Phone         string      `xml:"phone"`
+	Address       string      `xml:"address"`
+	Registered    RowDateTime `xml:"registered"`
+	FavoriteFruit string      `xml:"favoriteFruit"`
+}
+
+// RowDateTime - дата в формате датасета: "2017-02-05T06:23:27 -03:00"
+type RowDateTime struct {
+	time.Time
+}
+
+const RowDateTimeLayout = "2006-01-02T15:04:05 -07:00"
+
+func (t *RowDateTime) UnmarshalText(text []byte) error {
+	value, err := time.Parse(RowDateTimeLayout, strings.TrimSpace(string(text)))
+	if err != nil {
+		return err
+	}
+	t.Time = value
+
+	return nil
+}
+
+func (row Row) user() User {
+	return User{
+		ID:     row.ID,
+		Name:   fmt.Sprintf("%s %s", row.FirstName, row.LastName),
+		Age:    row.Age,
+		About:  row.About,
+		Gender: row.Gender,
+
+		GUID:          row.GUID,
+		IsActive:      row.IsActive,
+		Balance:       row.Balance,
+		Picture:       row.Picture,
+		EyeColor:      row.EyeColor,
+		Company:       row.Company,
+		Email:         row.Email,
+		Phone:         row.Phone,
+		Address:       row.Address,
+		Registered:    row.Registered.Time,
+		FavoriteFruit: row.FavoriteFruit,
+	}
 }
 
 type (
@@ -40,116 +90,411 @@ const (
 	OrderFieldAge   = "age"
 	OrderFieldName  = "name"
 	OrderFieldEmpty = ""
-
-	ErrorBadLimit   = "limit invalid"
-	ErrorBadOffset  = "offset invalid"
-	ErrorBadOrderBy = "order_by invalid"
+	// по убыванию (OrderByDesc) сначала самые релевантные запросу
+	OrderFieldRelevance = "relevance"
+
+	// больше MaxLimit строк за один запрос не отдается, клиент урезает Limit до него сам.
+	// Старые клиенты просят на строку больше, чтобы узнать про следующую страницу,
+	// поэтому в ResponseVersion1 допускается MaxLimit+1
+	MaxLimit = 25
+
+	ErrorBadLimit     = "limit invalid"
+	ErrorBadOffset    = "offset invalid"
+	ErrorBadOrderBy   = "order_by invalid"
+	ErrorBadFields    = "fields invalid"
+	ErrorBadQuery     = "query invalid"
+	ErrorBadMatch     = "match invalid"
+	ErrorBadHighlight = "highlight invalid"
+	ErrorBadCollation = "collation invalid"
+	ErrorBadCursor    = "cursor invalid"
+	ErrorBadVersion   = "version invalid"
+
+	// форматы ответа SearchServer, выбираются параметром version
+	ResponseVersion1 = 1 // массив пользователей
+	ResponseVersion2 = 2 // SearchEnvelope с общим числом найденных
 )
 
+// SearchEnvelope - ответ SearchServer в формате ResponseVersion2
+type SearchEnvelope struct {
+	Users  interface{}
+	Total  int // сколько всего пользователей подходит под запрос, без учета страницы и курсора
+	Limit  int
+	Offset int
+	// за страницей есть еще строки; курсора при этом может не быть, например при limit=0
+	More bool
+}
+
+// Server ищет пользователей в FileDataset, пропуская только запросы, прошедшие Auth.
+// Server без Auth отвечает 401 на любой запрос.
+type Server struct {
+	Auth Authenticator
+}
+
+// defaultServer принимает единственный токен "token", как раньше
+var defaultServer = &Server{Auth: StaticTokens{"token"}}
+
+// SearchServer - Server с токеном по умолчанию
 func SearchServer(w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("AccessToken") != "token" {
-		unauthorized(w)
+	defaultServer.ServeHTTP(w, r)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Auth == nil {
+		unauthorized(w, ErrorBadToken)
+		return
+	}
+	if err := s.Auth.Authenticate(r); err != nil {
+		unauthorized(w, err.Error())
 		return
 	}
 
-	users, err := loadUsers()
+	snapshot, err := datasets.Snapshot(FileDataset)
 	if err != nil {
 		internalServerError(w, err.Error())
 		return
 	}
 
-	limit, err := parseLimitParam(r)
+	params, err := parseSearchParams(r)
 	if err != nil {
 		badRequest(w, err.Error())
 		return
 	}
-	offset, err := parseOffsetParam(r)
+
+	// тот же датасет и те же параметры дают тот же ответ, его можно не считать
+	etag := searchETag(snapshot, r)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		setVersionHeaders(w, snapshot, etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	result, err := searchUsers(snapshot, params)
 	if err != nil {
 		badRequest(w, err.Error())
 		return
 	}
+	setVersionHeaders(w, snapshot, etag)
+	users := result.users
+	if result.more && len(users) > 0 {
+		cursor, err := encodeCursor(users[len(users)-1], params)
+		if err != nil {
+			internalServerError(w, err.Error())
+			return
+		}
+		w.Header().Set(HeaderNextCursor, cursor)
+	}
+
+	var highlights [][]Highlight
+	if params.highlight {
+		highlights = highlightUsers(users, params.query)
+	}
+
+	var data interface{} = users
+	switch {
+	case len(params.fields) > 0:
+		data = projectUsers(users, params.fields, highlights)
+	case highlights != nil:
+		hits := make([]searchHit, 0, len(users))
+		for i, user := range users {
+			hits = append(hits, searchHit{User: user, Highlights: highlights[i]})
+		}
+		data = hits
+	}
+
+	if params.version == ResponseVersion2 {
+		data = SearchEnvelope{Users: data, Total: result.total, Limit: params.limit, Offset: params.offset, More: result.more}
+	}
+	ok(w, data)
+}
+
+// searchParams - разобранные параметры запроса к SearchServer
+type searchParams struct {
+	limit     int
+	offset    int
+	sortKeys  []sortKey
+	collate   collator
+	query     searchQuery
+	fields    []string
+	highlight bool
+	// выдача, к которой привязаны курсоры, и курсор, после которого продолжить
+	scope  string
+	cursor *searchCursor
+	// ResponseVersion1 или ResponseVersion2
+	version int
+}
+
+func parseSearchParams(r *http.Request) (searchParams, error) {
+	var params searchParams
+	var err error
+
+	if params.version, err = parseVersionParam(r); err != nil {
+		return params, err
+	}
+	if params.limit, err = parseLimitParam(r, params.version); err != nil {
+		return params, err
+	}
+	if params.offset, err = parseOffsetParam(r); err != nil {
+		return params, err
+	}
 	orderField, err := parseOrderFieldParam(r)
 	if err != nil {
-		badRequest(w, err.Error())
-		return
+		return params, err
 	}
 	orderBy, err := parseOrderByParam(r)
 	if err != nil {
-		badRequest(w, err.Error())
-		return
+		return params, err
+	}
+	if params.sortKeys, err = parseOrderParam(r, orderField, orderBy); err != nil {
+		return params, err
+	}
+	if params.collate, err = parseCollationParam(r); err != nil {
+		return params, err
+	}
+	if params.fields, err = parseFieldsParam(r); err != nil {
+		return params, err
+	}
+	match, err := parseMatchParam(r)
+	if err != nil {
+		return params, err
+	}
+	if params.query, err = parseQueryParam(r, match); err != nil {
+		return params, err
+	}
+	if params.highlight, err = parseHighlightParam(r); err != nil {
+		return params, err
+	}
+	params.scope = cursorScope(r, params.sortKeys)
+	if params.cursor, err = parseCursorParam(r, params); err != nil {
+		return params, err
 	}
-	query := parseQueryParam(r)
 
-	users = sortUsers(users, orderBy, orderField)
-	users = queryUsers(users, query)
-	users = limitOffsetUsers(users, limit, offset)
+	return params, nil
+}
 
-	ok(w, users)
+// searchResult - страница выдачи и сведения обо всей выдаче
+type searchResult struct {
+	users Users
+	total int  // сколько пользователей подошло под запрос
+	more  bool // за страницей есть еще строки
 }
 
-func queryUsers(users Users, query string) Users {
-	unique := make(map[int]User)
-	for _, user := range users {
-		if len(query) > 0 {
-			if len(query) > 20 {
-				time.Sleep(time.Second / 10)
-			}
-			if strings.Contains(user.Name, query) || strings.Contains(user.About, query) {
-				unique[user.ID] = user
-				continue
-			}
-		} else {
-			unique[user.ID] = user
+// searchUsers - конвейер поиска: сначала фильтрация и отсечение по курсору, потом сортировка
+// только тех offset+limit строк, что попадут на страницу, и сама страница
+func searchUsers(snapshot *datasetSnapshot, params searchParams) (searchResult, error) {
+	query := params.query
+	if query.slow() {
+		time.Sleep(slowQueryDelay)
+	}
+	query.useIndex(snapshot.index)
+
+	users := queryUsers(snapshot.users, query)
+	result := searchResult{total: len(users)}
+	if slices.ContainsFunc(params.sortKeys, func(key sortKey) bool { return key.field == OrderFieldRelevance }) {
+		scoreUsers(users, query, snapshot)
+	}
+	if params.cursor != nil {
+		var err error
+		if users, err = params.cursor.after(users, params.sortKeys, params.collate); err != nil {
+			return result, err
 		}
 	}
+	result.more = len(users) > params.offset+params.limit
+
+	// равные по ключам строки идут по ID, чтобы курсор однозначно указывал место в выдаче
+	keys := params.sortKeys
+	if len(keys) > 0 {
+		keys = append(slices.Clip(keys), sortKey{field: OrderFieldID, orderBy: OrderByAsc})
+	}
+	users = topUsers(users, keys, params.collate, params.offset+params.limit)
+	result.users = limitOffsetUsers(users, params.limit, params.offset)
 
-	result := make(Users, 0, len(unique))
+	return result, nil
+}
+
+// slowQueryDelay - сколько ищется длинное слово без поля: эмуляция тяжелого поиска, на ней проверяются таймауты клиента
+const slowQueryDelay = 3 * time.Second / 2
+
+// searchQuery - разобранный параметр query
+type searchQuery struct {
+	raw  string
+	root queryNode
+	// ID пользователей, отобранных индексом; nil - проверяем всех
+	candidates map[int]bool
+}
+
+// slow сообщает, что запрос - одно слово без поля длиннее 20 символов, его поиск эмулирует тяжелый.
+// Запросы с полями, операторами и фразы в кавычках бывают длинными и так, их это не касается.
+func (q searchQuery) slow() bool {
+	n, ok := q.root.(textNode)
+	return ok && n.field == nil && len(q.raw) > 20 && !strings.ContainsAny(q.raw, " \t\r\n\"")
+}
+
+// useIndex ограничивает проверку кандидатами из индекса, если запрос им обслуживается
+func (q *searchQuery) useIndex(idx *userIndex) {
+	if q.root == nil || idx == nil {
+		return
+	}
+
+	ids, ok := idx.lookup(q.root)
+	if !ok {
+		return
+	}
+	q.candidates = make(map[int]bool, len(ids))
+	for _, id := range ids {
+		q.candidates[id] = true
+	}
+}
+
+// queryUsers возвращает новый срез с подходящими под запрос пользователями, users не меняется
+func queryUsers(users Users, query searchQuery) Users {
+	result := Users{}
 	for _, user := range users {
-		u, ok := unique[user.ID]
-		if !ok {
+		if matchUser(user, query) {
+			result = append(result, user)
+		}
+	}
+
+	return result
+}
+
+func matchUser(user User, query searchQuery) bool {
+	if query.root == nil {
+		return true
+	}
+	if query.candidates != nil && !query.candidates[user.ID] {
+		return false
+	}
+
+	return query.root.match(user)
+}
+
+// sortKey - поле сортировки и направление: OrderByAsc или OrderByDesc
+type sortKey struct {
+	field   string
+	orderBy int
+}
+
+// sortUsers сортирует по ключам по очереди: следующий ключ учитывается только при равенстве предыдущих.
+// Строковые поля сравниваются через collate (nil - побайтно).
+func sortUsers(users Users, keys []sortKey, collate collator) Users {
+	if len(keys) == 0 {
+		return users
+	}
+
+	slices.SortStableFunc(users, newUserComparator(keys, collate))
+
+	return users
+}
+
+// topUsers возвращает первые k пользователей в порядке sortUsers, не сортируя остальных.
+// Порядок равных сохраняется, как при устойчивой сортировке. users может быть переупорядочен.
+func topUsers(users Users, keys []sortKey, collate collator, k int) Users {
+	if len(keys) == 0 {
+		return users
+	}
+	// при большой доле нужных строк куча уже не выигрывает у обычной сортировки
+	if k >= len(users)/2 {
+		return sortUsers(users, keys, collate)
+	}
+	if k <= 0 {
+		return Users{}
+	}
+
+	compare := newUserComparator(keys, collate)
+	h := &userHeap{compare: compare}
+	for i, user := range users {
+		item := rankedUser{user: user, pos: i}
+		if h.Len() < k {
+			heap.Push(h, item)
 			continue
 		}
-		result = append(result, u)
+		// в вершине кучи худший из отобранных; равный ему, но более поздний, хуже
+		if h.less(item, h.items[0]) {
+			h.items[0] = item
+			heap.Fix(h, 0)
+		}
+	}
+
+	slices.SortFunc(h.items, func(a, b rankedUser) int {
+		if c := compare(a.user, b.user); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.pos, b.pos)
+	})
+	result := make(Users, 0, len(h.items))
+	for _, item := range h.items {
+		result = append(result, item.user)
 	}
 
 	return result
 }
 
-func sortUsers(users Users, orderBy int, orderField string) Users {
-	sort.SliceStable(users, func(i, j int) bool {
-		switch orderBy {
-		case OrderByAsc:
-			switch orderField {
-			case OrderFieldID:
-				return users[i].ID < users[j].ID
-			case OrderFieldAge:
-				return users[i].Age < users[j].Age
-			case OrderFieldName:
-				return users[i].Name < users[j].Name
+// newUserComparator собирает составное сравнение пользователей по ключам сортировки
+func newUserComparator(keys []sortKey, collate collator) func(a, b User) int {
+	compares := make([]func(a, b User) int, 0, len(keys))
+	for _, key := range keys {
+		field := userFields[key.field]
+		if field.text == nil || collate == nil {
+			compares = append(compares, field.compare)
+			continue
+		}
+		compares = append(compares, func(a, b User) int {
+			return collate(field.text(a), field.text(b))
+		})
+	}
+
+	return func(a, b User) int {
+		for i, compare := range compares {
+			c := compare(a, b)
+			if c == 0 {
+				continue
 			}
-		case OrderByDesc:
-			switch orderField {
-			case OrderFieldID:
-				return users[i].ID > users[j].ID
-			case OrderFieldAge:
-				return users[i].Age > users[j].Age
-			case OrderFieldName:
-				return users[i].Name > users[j].Name
+			if keys[i].orderBy == OrderByDesc {
+				return -c
 			}
+			return c
 		}
 
-		return false
-	})
+		return 0
+	}
+}
 
-	return users
+// rankedUser - пользователь и его позиция во входных данных, чтобы отбор через кучу был устойчивым
+type rankedUser struct {
+	user User
+	pos  int
 }
 
+// userHeap - куча, в вершине которой худший по порядку сортировки пользователь
+type userHeap struct {
+	items   []rankedUser
+	compare func(a, b User) int
+}
+
+// less сообщает, что a стоит в выдаче раньше b
+func (h *userHeap) less(a, b rankedUser) bool {
+	if c := h.compare(a.user, b.user); c != 0 {
+		return c < 0
+	}
+
+	return a.pos < b.pos
+}
+
+func (h *userHeap) Len() int           { return len(h.items) }
+func (h *userHeap) Less(i, j int) bool { return h.less(h.items[j], h.items[i]) }
+func (h *userHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *userHeap) Push(x interface{}) { h.items = append(h.items, x.(rankedUser)) }
+
+func (h *userHeap) Pop() interface{} {
+	item := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return item
+}
+
+// limitOffsetUsers вырезает страницу; offset за концом выдачи дает пустую страницу
 func limitOffsetUsers(users Users, limit, offset int) Users {
 	if offset >= len(users) {
-		offset = len(users) - 1
-		if offset < 0 {
-			offset = 0
-		}
+		return Users{}
 	}
 	realLimit := offset + limit
 	if realLimit > len(users) {
@@ -159,9 +504,14 @@ func limitOffsetUsers(users Users, limit, offset int) Users {
 	return users[offset:realLimit]
 }
 
-func parseLimitParam(r *http.Request) (int, error) {
+func parseLimitParam(r *http.Request, version int) (int, error) {
+	maxLimit := MaxLimit
+	if version == ResponseVersion1 {
+		maxLimit++
+	}
+
 	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
-	if err != nil || limit < 0 {
+	if err != nil || limit < 0 || limit > maxLimit {
 		return 0, fmt.Errorf(ErrorBadLimit)
 	}
 
@@ -179,17 +529,21 @@ func parseOffsetParam(r *http.Request) (int, error) {
 
 func parseOrderFieldParam(r *http.Request) (string, error) {
 	orderField := strings.ToLower(r.URL.Query().Get("order_field"))
-	switch orderField {
-	case OrderFieldID, OrderFieldAge, OrderFieldName:
-	case OrderFieldEmpty:
-		orderField = OrderFieldName
-	default:
+	if orderField == OrderFieldEmpty {
+		return OrderFieldName, nil
+	}
+	if !isSortField(orderField) {
 		return "", fmt.Errorf(ErrorBadOrderField)
 	}
 
 	return orderField, nil
 }
 
+func isSortField(field string) bool {
+	f, ok := userFields[field]
+	return ok && f.compare != nil
+}
+
 func parseOrderByParam(r *http.Request) (int, error) {
 	orderBy, err := strconv.Atoi(r.URL.Query().Get("order_by"))
 	if err != nil || orderBy < -1 || orderBy > 1 {
@@ -199,34 +553,123 @@ func parseOrderByParam(r *http.Request) (int, error) {
 	return orderBy, nil
 }
 
-func parseQueryParam(r *http.Request) string {
-	return r.URL.Query().Get("query")
+// parseOrderParam разбирает список ключей вида "age:desc,name:asc,id".
+// Без параметра order сортировка задается старой парой order_field и order_by.
+func parseOrderParam(r *http.Request, orderField string, orderBy int) ([]sortKey, error) {
+	param := r.URL.Query().Get("order")
+	if len(param) == 0 {
+		if orderBy == OrderByAsIs {
+			return nil, nil
+		}
+		return []sortKey{{field: orderField, orderBy: orderBy}}, nil
+	}
+
+	keys := []sortKey{}
+	for _, item := range strings.Split(param, ",") {
+		field, direction, _ := strings.Cut(strings.TrimSpace(item), ":")
+		key := sortKey{field: strings.ToLower(field), orderBy: OrderByAsc}
+
+		if !isSortField(key.field) {
+			return nil, fmt.Errorf(ErrorBadOrderField)
+		}
+
+		switch strings.ToLower(direction) {
+		case "", "asc":
+		case "desc":
+			key.orderBy = OrderByDesc
+		default:
+			return nil, fmt.Errorf(ErrorBadOrderBy)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func parseVersionParam(r *http.Request) (int, error) {
+	switch r.URL.Query().Get("version") {
+	case "", strconv.Itoa(ResponseVersion1):
+		return ResponseVersion1, nil
+	case strconv.Itoa(ResponseVersion2):
+		return ResponseVersion2, nil
+	}
+
+	return 0, fmt.Errorf(ErrorBadVersion)
+}
+
+func parseQueryParam(r *http.Request, m matcher) (searchQuery, error) {
+	raw := r.URL.Query().Get("query")
+	root, err := parseQuery(raw, m)
+	if err != nil {
+		return searchQuery{}, fmt.Errorf(ErrorBadQuery)
+	}
+
+	return searchQuery{raw: raw, root: root}, nil
 }
 
-func loadUsers() (Users, error) {
-	file, err := os.Open(FileDataset)
+// loadUsers читает датасет для снимка datasetStore. Строки идут потоком через scanUsers, без промежуточного
+// []Row, но все User остаются в памяти: снимок (и индекс по нему) держит весь датасет, фильтрация
+// идет по снимку, а не во время чтения файла
+func loadUsers(path string) (Users, os.FileInfo, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		return nil, fmt.Errorf("open file error")
+		return nil, nil, fmt.Errorf("open file error")
 	}
 	defer file.Close()
 
-	var dataset Dataset
-	if err := xml.NewDecoder(file).Decode(&dataset); err != nil {
-		return nil, err
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
 	}
 
-	users := make(Users, 0, len(dataset.Rows))
-	for _, row := range dataset.Rows {
-		users = append(users, User{
-			ID:     row.ID,
-			Name:   fmt.Sprintf("%s %s", row.FirstName, row.LastName),
-			Age:    row.Age,
-			About:  row.About,
-			Gender: row.Gender,
-		})
+	users := Users{}
+	err = scanUsers(file, func(user User) error {
+		users = append(users, user)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return users, nil
+	return users, info, nil
+}
+
+// scanUsers потоково читает элементы <row> и отдает их по одному в fn.
+// Ошибка из fn прерывает чтение и возвращается наружу.
+// Документ без корневого элемента (пустой или не xml) - ошибка, а не пустой датасет.
+func scanUsers(r io.Reader, fn func(User) error) error {
+	decoder := xml.NewDecoder(r)
+	seenRoot := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			if !seenRoot {
+				return fmt.Errorf("dataset has no root element")
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		seenRoot = true
+		if start.Name.Local != "row" {
+			continue
+		}
+
+		var row Row
+		if err := decoder.DecodeElement(&row, &start); err != nil {
+			return err
+		}
+		if err := fn(row.user()); err != nil {
+			return err
+		}
+	}
 }
 
 func internalServerError(w http.ResponseWriter, desc string) {
@@ -258,10 +701,6 @@ func badRequest(w http.ResponseWriter, desc string) {
 	}
 }
 
-func unauthorized(w http.ResponseWriter) {
-	w.WriteHeader(http.StatusUnauthorized)
-}
-
 func ok(w http.ResponseWriter, data interface{}) {
 	resp, err := json.Marshal(data)
 	if err != nil {
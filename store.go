@@ -0,0 +1,160 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// datasetSnapshot - разобранный датасет, загруженный из файла path
+type datasetSnapshot struct {
+	path    string
+	modTime time.Time
+	size    int64
+	users   Users
+	index   *userIndex
+}
+
+// changed сообщает, отличается ли файл на диске от того, из которого собран снимок
+func (s *datasetSnapshot) changed(info os.FileInfo) bool {
+	return !info.ModTime().Equal(s.modTime) || info.Size() != s.size
+}
+
+// datasetStore держит датасет в памяти, чтобы не разбирать xml на каждый запрос
+type datasetStore struct {
+	mu       sync.Mutex // сериализует загрузки файла
+	snapshot atomic.Pointer[datasetSnapshot]
+
+	// последняя неудачная попытка перечитать файл: ее mtime/size и ошибка
+	failed    *datasetSnapshot
+	reloadErr error
+
+	// как часто Snapshot проверяет файл на диске, 0 - datasetCheckInterval
+	checkInterval time.Duration
+	// когда файл проверялся последний раз, UnixNano
+	checkedAt atomic.Int64
+}
+
+// datasetCheckInterval - не чаще этого Snapshot смотрит, не изменился ли файл датасета
+const datasetCheckInterval = time.Second
+
+var datasets = &datasetStore{}
+
+// Snapshot возвращает текущий снимок датасета path.
+// Файл читается при первом обращении (или если сменился путь), ошибка загрузки не кешируется.
+// Раз в checkInterval Snapshot проверяет mtime и размер файла и перечитывает его через Reload.
+// Снимок общий для всех запросов, менять его нельзя.
+func (s *datasetStore) Snapshot(path string) (*datasetSnapshot, error) {
+	snapshot := s.snapshot.Load()
+	if snapshot != nil && snapshot.path == path {
+		if s.checkDue() {
+			s.reloadAndLog()
+			snapshot = s.snapshot.Load()
+		}
+		return snapshot, nil
+	}
+
+	return s.load(path)
+}
+
+// checkDue сообщает, пора ли проверить файл; из одновременных запросов проверяет только один
+func (s *datasetStore) checkDue() bool {
+	interval := s.checkInterval
+	if interval <= 0 {
+		interval = datasetCheckInterval
+	}
+	now := time.Now().UnixNano()
+	checkedAt := s.checkedAt.Load()
+
+	return now-checkedAt >= int64(interval) && s.checkedAt.CompareAndSwap(checkedAt, now)
+}
+
+// reloadAndLog перечитывает файл и пишет в лог новую ошибку; уже известная ошибка не повторяется
+func (s *datasetStore) reloadAndLog() {
+	prevErr := s.ReloadError()
+	if err := s.Reload(); err != nil && err != prevErr {
+		log.Printf("dataset reload error: %s", err)
+	}
+}
+
+func (s *datasetStore) load(path string) (*datasetSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if snapshot := s.snapshot.Load(); snapshot != nil && snapshot.path == path {
+		return snapshot, nil
+	}
+
+	snapshot, err := readSnapshot(path)
+	if err != nil {
+		return nil, err
+	}
+	s.snapshot.Store(snapshot)
+	s.failed, s.reloadErr = nil, nil
+	s.checkedAt.Store(time.Now().UnixNano())
+
+	return snapshot, nil
+}
+
+// Reload перечитывает файл текущего снимка, если у него поменялись mtime или размер.
+// При ошибке разбора продолжает работать старый снимок, а ошибка доступна через ReloadError.
+func (s *datasetStore) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := s.snapshot.Load()
+	if current == nil {
+		return nil
+	}
+
+	info, err := os.Stat(current.path)
+	if err != nil {
+		return s.reloadFailed(&datasetSnapshot{path: current.path}, err)
+	}
+	if !current.changed(info) {
+		return nil
+	}
+	// битый файл не разбираем повторно, пока он снова не изменится
+	if s.failed != nil && s.failed.path == current.path && !s.failed.changed(info) {
+		return s.reloadErr
+	}
+
+	snapshot, err := readSnapshot(current.path)
+	if err != nil {
+		return s.reloadFailed(&datasetSnapshot{path: current.path, modTime: info.ModTime(), size: info.Size()}, err)
+	}
+	s.snapshot.Store(snapshot)
+	s.failed, s.reloadErr = nil, nil
+
+	return nil
+}
+
+func (s *datasetStore) reloadFailed(failed *datasetSnapshot, err error) error {
+	s.failed, s.reloadErr = failed, err
+	return err
+}
+
+// ReloadError возвращает ошибку последней неудачной перезагрузки или nil, если снимок актуален
+func (s *datasetStore) ReloadError() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.reloadErr
+}
+
+func readSnapshot(path string) (*datasetSnapshot, error) {
+	users, info, err := loadUsers(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &datasetSnapshot{
+		path:    path,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		users:   users,
+		index:   newUserIndex(users),
+	}, nil
+}